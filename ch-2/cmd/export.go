@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"net/url"
+	"os"
+	"time"
+	"wikistats/pkg/api"
+	"wikistats/pkg/database"
+	"wikistats/pkg/export"
+	"wikistats/pkg/models"
+)
+
+// Write a dataset to a file without a running server, reading events from WAL_DIR and aggregates from
+// SNAPSHOT_FILE. The WAL only holds events since about the last snapshot, so unlike /export/events older
+// events are missing once it has been compacted. Run as
+// main export -dataset events -format parquet -query "wiki=enwiki&since=24h" -out enwiki.parquet
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dataset := flags.String("dataset", export.Events, "dataset to export: events, wikis or namespaces")
+	formatName := flags.String("format", string(export.CSV), "csv or parquet")
+	filters := flags.String("query", "", "event filters in the form of the /events query string")
+	out := flags.String("out", "", "file to write, standard output when empty")
+	flags.Parse(args)
+
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	var write func(w io.Writer) (int, error)
+	switch *dataset {
+	case export.Events:
+		dir := os.Getenv("WAL_DIR")
+		if dir == "" {
+			return errors.New("exporting events needs WAL_DIR")
+		}
+		params, err := url.ParseQuery(*filters)
+		if err != nil {
+			return fmt.Errorf("parsing query: %w", err)
+		}
+		query, err := api.ParseEventQuery(params, time.Now())
+		if err != nil {
+			return err
+		}
+		compacted, err := database.WALCompacted(dir)
+		if err != nil {
+			return fmt.Errorf("reading WAL: %w", err)
+		}
+		if compacted {
+			log.Printf("Warning: %s has been compacted, so only events since about the last snapshot are exported", dir)
+		}
+		write = func(w io.Writer) (int, error) {
+			return export.WriteEvents(w, format, matching(database.ReadWAL(dir), query))
+		}
+	case export.Wikis, export.Namespaces:
+		filename := os.Getenv("SNAPSHOT_FILE")
+		if filename == "" {
+			return fmt.Errorf("exporting %s needs SNAPSHOT_FILE", *dataset)
+		}
+		db := database.NewInMemoryDatabase()
+		if _, err := database.LoadSnapshot(filename, db); err != nil {
+			return fmt.Errorf("loading snapshot: %w", err)
+		}
+		write = func(w io.Writer) (int, error) {
+			if *dataset == export.Wikis {
+				return export.WriteWikis(w, format, db.GetWikiCounts())
+			}
+			return export.WriteNamespaces(w, format, db.GetBreakdownStats())
+		}
+	default:
+		return fmt.Errorf("unknown dataset %q", *dataset)
+	}
+
+	output := os.Stdout
+	if *out != "" {
+		if output, err = os.Create(*out); err != nil {
+			return err
+		}
+	}
+	rows, err := write(output)
+	if *out != "" {
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Exported %d rows of %s", rows, *dataset)
+	if rows == 0 && *dataset == export.Events {
+		log.Printf("Warning: no events matched, and the WAL only holds events since about the last snapshot")
+	}
+	return nil
+}
+
+// The events matching the query's filters
+func matching(events iter.Seq2[models.Event, error], query database.EventQuery) iter.Seq2[models.Event, error] {
+	return func(yield func(models.Event, error) bool) {
+		for event, err := range events {
+			if err == nil && !query.Match(event) {
+				continue
+			}
+			if !yield(event, err) {
+				return
+			}
+		}
+	}
+}
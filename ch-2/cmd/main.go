@@ -9,12 +9,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"wikistats/pkg/analysis"
 	"wikistats/pkg/api"
 	"wikistats/pkg/consumer"
 	"wikistats/pkg/database"
+	"wikistats/pkg/firehose"
+	"wikistats/pkg/geoip"
+	"wikistats/pkg/models"
+	"wikistats/pkg/notify"
 	"wikistats/pkg/utils"
 )
 
@@ -27,16 +33,183 @@ func main() {
 			log.Printf("Could not load env file: %v", err)
 		}
 	}
+	if flag.Arg(0) == "export" {
+		if err := runExport(flag.Args()[1:]); err != nil {
+			log.Fatalf("Error exporting: %v", err)
+		}
+		return
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	db := database.NewInMemoryDatabase()
-	router := api.NewRouter(api.NewService(db))
+	db := database.NewInMemoryDatabase().
+		WithTrending(
+			time.Duration(utils.GetEnvInt("TRENDING_WINDOW", 0))*time.Second,
+			time.Duration(utils.GetEnvInt("TRENDING_HALF_LIFE", 0))*time.Second,
+		).
+		WithAnonymousPrefixes(utils.GetEnvInt("ANONYMOUS_IPV4_PREFIX", 0), utils.GetEnvInt("ANONYMOUS_IPV6_PREFIX", 0)).
+		WithCrossWikiWindow(time.Duration(utils.GetEnvInt("CROSS_WIKI_WINDOW", 0))*time.Second).
+		WithPageRetention(time.Duration(utils.GetEnvInt("PAGE_RETENTION", 0))*time.Second).
+		WithRetention(
+			time.Duration(utils.GetEnvInt("MESSAGE_RETENTION", 0))*time.Second,
+			time.Duration(utils.GetEnvInt("SET_TTL", 0))*time.Second,
+		)
+	// The core stats live in the configured storage backend, and every other aggregate in memory
+	var stored database.Executer = db
+	if backend := os.Getenv("STORAGE_BACKEND"); backend != "" && backend != database.BackendMemory {
+		store, err := database.OpenStore(backend, os.Getenv("STORAGE_LOCATION"))
+		if err != nil {
+			log.Fatalf("Error opening %s storage: %v", backend, err)
+		}
+		defer store.Close()
+		stored = database.NewStoredDatabase(store, db)
+	}
+	hub := firehose.NewHub(utils.GetEnvInt("FIREHOSE_BUFFER", 256)).
+		WithAllowedOrigins(strings.Split(os.Getenv("FIREHOSE_ORIGINS"), ","))
+	classifier := analysis.NewRevertClassifier()
+	if patterns := os.Getenv("REVERT_PATTERNS"); patterns != "" {
+		var err error
+		if classifier, err = analysis.LoadRevertClassifier(patterns); err != nil {
+			log.Fatalf("Error loading revert patterns: %v", err)
+		}
+	}
+	reverts := analysis.NewRevertTracker(classifier).
+		WithUserTTL(time.Duration(utils.GetEnvInt("SET_TTL", 0)) * time.Second)
+	editWars := analysis.NewEditWarDetector(
+		time.Duration(utils.GetEnvInt("EDIT_WAR_WINDOW", 0))*time.Second,
+		utils.GetEnvInt("EDIT_WAR_REVERTS", 0),
+		classifier,
+	)
+	rules := analysis.DefaultVandalismRules()
+	if filename := os.Getenv("VANDALISM_RULES"); filename != "" {
+		var err error
+		if rules, err = analysis.LoadVandalismRules(filename); err != nil {
+			log.Fatalf("Error loading vandalism rules: %v", err)
+		}
+	}
+	scorer := analysis.NewVandalismScorer(rules, db)
+	sessions := analysis.NewSessionTracker(
+		time.Duration(utils.GetEnvInt("SESSION_GAP", 0))*time.Second,
+		utils.GetEnvInt("SESSION_MAX_OPEN", 0),
+	)
+	anomalies := analysis.NewAnomalyDetector(
+		time.Duration(utils.GetEnvInt("ANOMALY_INTERVAL", 0))*time.Second,
+		float64(utils.GetEnvInt("ANOMALY_THRESHOLD", 0)),
+		float64(utils.GetEnvInt("ANOMALY_MIN_RATE", 0)),
+	)
+	var notifier *notify.WebhookNotifier
+	if urls := os.Getenv("WEBHOOK_URLS"); urls != "" {
+		notifier = notify.NewWebhookNotifier(strings.Split(urls, ","), os.Getenv("WEBHOOK_SECRET")).
+			WithRetries(utils.GetEnvInt("WEBHOOK_ATTEMPTS", 0), time.Duration(utils.GetEnvInt("WEBHOOK_BACKOFF", 0))*time.Second).
+			WithDeadLetter(os.Getenv("WEBHOOK_DEAD_LETTER")).
+			WithRateLimit(time.Duration(utils.GetEnvInt("WEBHOOK_RATE_LIMIT", 0))*time.Second, utils.GetEnvInt("WEBHOOK_RATE_BURST", 0))
+		anomalies.WithNotifier(notifier)
+		editWars.WithNotifier(notifier)
+	}
+	var alertRules *analysis.RuleEngine
+	if filename := os.Getenv("ALERT_RULES"); filename != "" {
+		var err error
+		if alertRules, err = analysis.NewRuleEngine(filename, db); err != nil {
+			log.Fatalf("Error loading alert rules: %v", err)
+		}
+		if notifier != nil {
+			alertRules.WithNotifier(notifier)
+		}
+	}
+	service := api.NewService(stored).
+		WithFirehose(hub).
+		WithEditWars(editWars).
+		WithReverts(reverts).
+		WithVandalismScorer(scorer).
+		WithSessions(sessions).
+		WithAnomalies(anomalies).
+		WithRules(alertRules).
+		WithNotifier(notifier)
 	streamConsumer, err := consumer.NewWikimediaConsumer(os.Getenv("STREAM_URL"))
 	if err != nil {
 		log.Fatalf("Error initializing consumer: %v", err)
 	}
+	if filename := os.Getenv("GEOIP_DATABASE"); filename != "" {
+		geoDB, err := geoip.Open(filename)
+		if err != nil {
+			log.Fatalf("Error loading GeoIP database: %v", err)
+		}
+		streamConsumer.AddEnricher(geoip.NewEnricher(geoDB))
+	}
+	dedupe := consumer.NewDeduplicator(
+		utils.GetEnvInt("DEDUPE_RECENT", 0),
+		utils.GetEnvInt("DEDUPE_FILTER_CAPACITY", 0),
+		float64(utils.GetEnvInt("DEDUPE_FALSE_POSITIVE_PPM", 0))/1e6,
+	)
+	// Restore the aggregates from the last snapshot and pick the stream up where the snapshot left off
+	var snapshot database.SnapshotInfo
+	snapshotFile := os.Getenv("SNAPSHOT_FILE")
+	if snapshotFile != "" {
+		info, err := database.LoadSnapshot(snapshotFile, db)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			log.Printf("No snapshot at %s, starting empty", snapshotFile)
+		case err != nil:
+			log.Fatalf("Error loading snapshot: %v", err)
+		default:
+			log.Printf("Restored snapshot taken %v, resuming from %s", info.Time, info.Checkpoint)
+			snapshot = info
+			// The stream replays from the checkpoint, so skip the messages the snapshot already holds
+			for _, id := range db.RecentMessageIDs() {
+				dedupe.Remember(id)
+			}
+			if info.Checkpoint != "" {
+				streamConsumer.ResumeFrom(info.Checkpoint)
+			}
+		}
+	}
+	// Replay the events logged after the snapshot through the storage backend as live events go, and
+	// resume the stream after the last of them
+	recorder := stored
+	var walRecorder *database.WALRecorder
+	if dir := os.Getenv("WAL_DIR"); dir != "" {
+		wal, err := database.OpenWAL(
+			dir,
+			int64(utils.GetEnvInt("WAL_SEGMENT_SIZE", 0)),
+			database.SyncPolicy(os.Getenv("WAL_SYNC")),
+			time.Duration(utils.GetEnvInt("WAL_SYNC_INTERVAL", 0))*time.Millisecond,
+		)
+		if err != nil {
+			log.Fatalf("Error opening WAL: %v", err)
+		}
+		defer wal.Close()
+		var last time.Time
+		replayed, err := wal.Replay(snapshot.WAL, func(event models.Event) {
+			database.Record(stored, event)
+			dedupe.Remember(event.ID)
+			last = event.Time
+		})
+		if err != nil {
+			log.Fatalf("Error replaying WAL: %v", err)
+		}
+		log.Printf("Replayed %d events from the WAL in %s", replayed, dir)
+		if checkpoint, _ := time.Parse(time.RFC3339, snapshot.Checkpoint); last.After(checkpoint) {
+			streamConsumer.ResumeFrom(last.Format(time.RFC3339Nano))
+		}
+		walRecorder = database.NewWALRecorder(stored, wal)
+		recorder = walRecorder
+	}
+	streamConsumer.SetDeduplicator(dedupe)
+	service.WithDeduplicator(dedupe)
+	streamConsumer.AddHandler(hub)
+	streamConsumer.AddHandler(editWars)
+	streamConsumer.AddHandler(reverts)
+	streamConsumer.AddHandler(scorer)
+	streamConsumer.AddHandler(sessions)
+	streamConsumer.AddHandler(anomalies)
+	// The event store is optional since it holds full events rather than aggregates
+	if size := utils.GetEnvInt("EVENT_STORE_SIZE", 0); size > 0 {
+		eventStore := database.NewInMemoryEventStore(size)
+		service.WithEventStore(eventStore)
+		streamConsumer.AddHandler(eventStore)
+	}
+	router := api.NewRouter(service)
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", os.Getenv("API_PORT")),
 		Handler:      router,
@@ -60,6 +233,36 @@ func main() {
 		}
 	}()
 	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		anomalies.Run(ctx)
+	}()
+	if snapshotFile != "" {
+		snapshotter := database.NewSnapshotter(db, snapshotFile, streamConsumer.Checkpoint)
+		if walRecorder != nil {
+			snapshotter.WithWAL(walRecorder)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			snapshotter.Run(ctx, time.Duration(utils.GetEnvInt("SNAPSHOT_INTERVAL", 0))*time.Second)
+		}()
+	}
+	if alertRules != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			alertRules.Run(ctx, time.Duration(utils.GetEnvInt("ALERT_RULES_INTERVAL", 0))*time.Second)
+		}()
+	}
+	if notifier != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			notifier.Run(ctx)
+		}()
+	}
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		log.Println("Starting consumer")
@@ -70,8 +273,8 @@ func main() {
 			cancel()
 			return
 		}
-		if err = streamConsumer.Consume(ctx, stream, db); err != nil && !errors.Is(err, context.Canceled) {
-			log.Println(db.GetStats())
+		if err = streamConsumer.Consume(ctx, stream, recorder); err != nil && !errors.Is(err, context.Canceled) {
+			log.Println(stored.GetStats())
 			log.Println(time.Since(start))
 			log.Printf("Consumer failed: %v", err)
 			cancel()
@@ -87,6 +290,8 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
+	// WebSocket connections are hijacked, so the server shutdown doesn't close them
+	hub.Close()
 	wg.Wait()
 	log.Println("Application terminated")
 }
@@ -0,0 +1,250 @@
+package analysis
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	DefaultAnomalyInterval = time.Minute
+	// Deviations above the baseline a message count must reach to be a spike
+	DefaultAnomalyThreshold = 4
+	// Messages per interval a series must average before it can alert, which ignores quiet wikis
+	DefaultAnomalyMinRate = 5
+	// Name of the series counting messages from every wiki
+	GlobalSeries = "*"
+	// Weight of the newest interval in the baselines, and while an anomaly is active
+	anomalyAlpha       = 0.1
+	anomalyActiveAlpha = 0.01
+	// Intervals counted before a series can alert
+	anomalyWarmup = 10
+	// Fraction of an interval messages may lag behind the stream before they're taken as catch-up
+	anomalyLagFraction = 4
+	// Resolved anomalies kept for the alerts feed
+	anomalyHistorySize = 500
+)
+
+type AnomalyKind string
+
+const (
+	Spike AnomalyKind = "spike"
+	Drop  AnomalyKind = "drop"
+)
+
+// A period where a wiki's message rate, or the global rate, left its normal range
+type Anomaly struct {
+	ID       int         `json:"id"`
+	Wiki     string      `json:"wiki"`
+	Kind     AnomalyKind `json:"kind"`
+	Start    time.Time   `json:"start"`
+	End      *time.Time  `json:"end"`
+	Expected float64     `json:"expected_per_interval"`
+	Peak     int         `json:"peak_per_interval"`
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%s in %s messages, expected %.1f per interval, peak %d", a.Kind, a.Wiki, a.Expected, a.Peak)
+}
+
+// Exponentially weighted mean and variance of messages per interval
+type throughputSeries struct {
+	count     int
+	intervals int
+	mean      float64
+	variance  float64
+	active    *Anomaly
+}
+
+// AnomalyDetector counts messages per wiki in fixed intervals of wall clock time, so it notices the
+// stream going quiet, and raises anomalies when a count is far above or drops to zero from its baseline.
+// Messages whose event time is from an interval already closed were replayed or caught up after an
+// outage, and aren't counted, so the burst after reconnecting doesn't look like a spike
+type AnomalyDetector struct {
+	lock      sync.Mutex
+	interval  time.Duration
+	threshold float64
+	minRate   float64
+	series    map[string]*throughputSeries
+	// Start of the interval currently being counted
+	current  time.Time
+	resolved []Anomaly
+	next     int
+	lastID   int
+	notifier Notifier
+	now      func() time.Time
+}
+
+func NewAnomalyDetector(interval time.Duration, threshold float64, minRate float64) *AnomalyDetector {
+	if interval <= 0 {
+		interval = DefaultAnomalyInterval
+	}
+	if threshold <= 0 {
+		threshold = DefaultAnomalyThreshold
+	}
+	if minRate <= 0 {
+		minRate = DefaultAnomalyMinRate
+	}
+	return &AnomalyDetector{
+		interval:  interval,
+		threshold: threshold,
+		minRate:   minRate,
+		series:    map[string]*throughputSeries{GlobalSeries: {}},
+		resolved:  make([]Anomaly, 0, anomalyHistorySize),
+		now:       time.Now,
+	}
+}
+
+// Notify when anomalies start and end
+func (d *AnomalyDetector) WithNotifier(notifier Notifier) *AnomalyDetector {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.notifier = notifier
+	return d
+}
+
+func (d *AnomalyDetector) HandleEvent(event models.Event) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.advance(d.now())
+	if !event.Time.IsZero() && event.Time.Before(d.current.Add(-d.interval/anomalyLagFraction)) {
+		return
+	}
+	series, ok := d.series[event.Wiki]
+	if !ok {
+		series = &throughputSeries{}
+		d.series[event.Wiki] = series
+	}
+	series.count++
+	d.series[GlobalSeries].count++
+}
+
+// Close intervals as time passes, even while no messages arrive, until the context is canceled
+func (d *AnomalyDetector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.lock.Lock()
+			d.advance(d.now())
+			d.lock.Unlock()
+		}
+	}
+}
+
+// Close every interval that ended by now. Caller must hold the lock
+func (d *AnomalyDetector) advance(now time.Time) {
+	if d.current.IsZero() {
+		d.current = now.Truncate(d.interval)
+		return
+	}
+	for !now.Before(d.current.Add(d.interval)) {
+		d.current = d.current.Add(d.interval)
+		for wiki, series := range d.series {
+			d.close(wiki, series, d.current)
+		}
+	}
+}
+
+// Compare the finished interval's count with the baseline, then fold it into the baseline
+func (d *AnomalyDetector) close(wiki string, series *throughputSeries, end time.Time) {
+	count := series.count
+	series.count = 0
+	deviation := math.Sqrt(series.variance)
+	var kind AnomalyKind
+	if series.intervals >= anomalyWarmup && series.mean >= d.minRate {
+		// The deviation is floored so series with very steady rates don't alert on small changes
+		if float64(count) > series.mean+d.threshold*max(deviation, math.Sqrt(series.mean)) {
+			kind = Spike
+		} else if count == 0 {
+			kind = Drop
+		}
+	}
+
+	switch {
+	case series.active != nil && series.active.Kind == kind:
+		series.active.Peak = max(series.active.Peak, count)
+	case series.active != nil:
+		d.resolve(series, end)
+	}
+	if kind != "" && series.active == nil {
+		d.lastID++
+		series.active = &Anomaly{ID: d.lastID, Wiki: wiki, Kind: kind, Start: end.Add(-d.interval), Expected: series.mean, Peak: count}
+		log.Printf("Anomaly started: %s", series.active)
+		d.notify(*series.active, models.Firing, end)
+	}
+	series.intervals++
+	if series.intervals == 1 {
+		series.mean = float64(count)
+		return
+	}
+	// Anomalous intervals move the baseline slowly, so a flood or outage doesn't immediately
+	// become the new normal but a lasting change in rate eventually does
+	alpha := anomalyAlpha
+	if series.active != nil {
+		alpha = anomalyActiveAlpha
+	}
+	difference := float64(count) - series.mean
+	series.mean += alpha * difference
+	series.variance = (1 - alpha) * (series.variance + alpha*difference*difference)
+}
+
+func (d *AnomalyDetector) resolve(series *throughputSeries, end time.Time) {
+	anomaly := *series.active
+	anomaly.End = &end
+	series.active = nil
+	log.Printf("Anomaly resolved: %s", anomaly)
+	d.notify(anomaly, models.Resolved, end)
+	if len(d.resolved) < cap(d.resolved) {
+		d.resolved = append(d.resolved, anomaly)
+		return
+	}
+	d.resolved[d.next] = anomaly
+	d.next = (d.next + 1) % len(d.resolved)
+}
+
+func (d *AnomalyDetector) notify(anomaly Anomaly, state models.AlertState, now time.Time) {
+	if d.notifier == nil {
+		return
+	}
+	d.notifier.Notify(models.Alert{
+		Rule:    "anomaly/" + anomaly.Wiki,
+		Source:  "anomaly",
+		State:   state,
+		Time:    now,
+		Summary: anomaly.String(),
+		Details: anomaly,
+	})
+}
+
+// Active anomalies and, unless activeOnly is set, recently resolved ones, most recently started first
+func (d *AnomalyDetector) Anomalies(activeOnly bool) []Anomaly {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.advance(d.now())
+	anomalies := make([]Anomaly, 0)
+	for _, series := range d.series {
+		if series.active != nil {
+			anomalies = append(anomalies, *series.active)
+		}
+	}
+	if !activeOnly {
+		anomalies = append(anomalies, d.resolved...)
+	}
+	slices.SortFunc(anomalies, func(a, b Anomaly) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return anomalies
+}
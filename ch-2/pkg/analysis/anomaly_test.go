@@ -0,0 +1,120 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+	"wikistats/pkg/models"
+)
+
+// Feed the detector the given message counts per wiki for each one minute interval
+func feedIntervals(detector *AnomalyDetector, clock *time.Time, counts ...map[string]int) {
+	for _, interval := range counts {
+		for wiki, count := range interval {
+			for range count {
+				detector.HandleEvent(models.Event{Wiki: wiki})
+			}
+		}
+		*clock = clock.Add(time.Minute)
+	}
+}
+
+func steady(counts map[string]int, intervals int) []map[string]int {
+	steady := make([]map[string]int, intervals)
+	for i := range steady {
+		steady[i] = counts
+	}
+	return steady
+}
+
+func TestAnomalies(t *testing.T) {
+	clock := start
+	detector := NewAnomalyDetector(time.Minute, 4, 5)
+	detector.now = func() time.Time { return clock }
+
+	feedIntervals(detector, &clock, steady(map[string]int{"enwiki": 100, "dewiki": 50, "tinywiki": 1}, 20)...)
+	if anomalies := detector.Anomalies(false); len(anomalies) != 0 {
+		t.Fatalf("Anomalies during steady traffic: %+v", anomalies)
+	}
+
+	// A bot flood on dewiki while enwiki goes quiet
+	feedIntervals(detector, &clock, steady(map[string]int{"dewiki": 500}, 3)...)
+	active := detector.Anomalies(true)
+	kinds := make(map[string]AnomalyKind)
+	for _, anomaly := range active {
+		if anomaly.End != nil {
+			t.Errorf("Active anomaly has an end: %+v", anomaly)
+		}
+		kinds[anomaly.Wiki] = anomaly.Kind
+	}
+	if kinds["dewiki"] != Spike || kinds["enwiki"] != Drop {
+		t.Errorf("kinds: got %v, want dewiki spike and enwiki drop", kinds)
+	}
+	if _, ok := kinds["tinywiki"]; ok {
+		t.Error("Quiet wiki alerted")
+	}
+	for _, anomaly := range active {
+		if anomaly.Wiki == "dewiki" && (anomaly.Peak != 500 || !anomaly.Start.Equal(start.Add(20*time.Minute))) {
+			t.Errorf("dewiki: got %+v, want peak 500 starting after 20 minutes", anomaly)
+		}
+	}
+
+	// Traffic returns to normal
+	feedIntervals(detector, &clock, steady(map[string]int{"enwiki": 100, "dewiki": 50, "tinywiki": 1}, 2)...)
+	if active := detector.Anomalies(true); len(active) != 0 {
+		t.Errorf("Anomalies still active: %+v", active)
+	}
+	for _, anomaly := range detector.Anomalies(false) {
+		if anomaly.End == nil || !anomaly.End.After(anomaly.Start) {
+			t.Errorf("Resolved anomaly without a valid end: %+v", anomaly)
+		}
+		if anomaly.Wiki == "enwiki" && !anomaly.End.Equal(start.Add(24*time.Minute)) {
+			t.Errorf("enwiki outage end: got %v, want after 24 minutes", anomaly.End)
+		}
+	}
+}
+
+func TestAnomaliesWithoutMessages(t *testing.T) {
+	clock := start
+	detector := NewAnomalyDetector(time.Minute, 4, 5)
+	detector.now = func() time.Time { return clock }
+	feedIntervals(detector, &clock, steady(map[string]int{"enwiki": 100}, 20)...)
+
+	// The whole stream stops, so only the passing time closes intervals
+	clock = clock.Add(5 * time.Minute)
+	active := detector.Anomalies(true)
+	if len(active) != 2 {
+		t.Fatalf("active: got %+v, want enwiki and global drops", active)
+	}
+	for _, anomaly := range active {
+		if anomaly.Kind != Drop {
+			t.Errorf("kind: got %s, want drop", anomaly.Kind)
+		}
+	}
+}
+
+func TestAnomaliesAfterReconnect(t *testing.T) {
+	clock := start
+	detector := NewAnomalyDetector(time.Minute, 4, 5)
+	detector.now = func() time.Time { return clock }
+	feedIntervals(detector, &clock, steady(map[string]int{"enwiki": 100}, 20)...)
+
+	// The stream is down for three minutes, then the missed messages arrive along with new ones
+	outage := clock
+	clock = clock.Add(3 * time.Minute)
+	for i := range 300 {
+		detector.HandleEvent(models.Event{Wiki: "enwiki", Time: outage.Add(time.Duration(i) * 600 * time.Millisecond)})
+	}
+	for i := range 100 {
+		detector.HandleEvent(models.Event{Wiki: "enwiki", Time: clock.Add(time.Duration(i) * 600 * time.Millisecond)})
+	}
+	clock = clock.Add(time.Minute)
+
+	for _, anomaly := range detector.Anomalies(false) {
+		if anomaly.Kind != Drop {
+			t.Errorf("Unexpected anomaly after reconnecting: %+v", anomaly)
+		}
+	}
+	if active := detector.Anomalies(true); len(active) != 0 {
+		t.Errorf("Anomalies still active: %+v", active)
+	}
+}
@@ -0,0 +1,233 @@
+package analysis
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	DefaultEditWarWindow  = time.Hour
+	DefaultEditWarReverts = 3
+	// Edits kept per page, enough to see several rounds of back and forth
+	maxPageHistory = 50
+)
+
+// A page being reverted back and forth between users
+type EditWar struct {
+	Wiki         string         `json:"wiki"`
+	Title        string         `json:"title"`
+	Participants map[string]int `json:"participants"`
+	Edits        int            `json:"edits"`
+	Reverts      int            `json:"reverts"`
+	Started      time.Time      `json:"started"`
+	LastActivity time.Time      `json:"last_activity"`
+}
+
+// EditWarDetector watches edits for pages where users keep reverting each other
+type EditWarDetector struct {
+	lock       sync.Mutex
+	window     time.Duration
+	minReverts int
+	classifier *RevertClassifier
+	notifier   Notifier
+	pages      map[pageKey]*pageHistory
+	latest     time.Time
+	lastSweep  time.Time
+}
+
+type pageKey struct {
+	wiki  string
+	title string
+}
+
+type pageEdit struct {
+	user        string
+	time        time.Time
+	oldLength   int
+	newLength   int
+	newRevision int
+	revert      bool
+}
+
+type pageHistory struct {
+	edits []pageEdit
+	// Whether a firing alert has been sent for the page
+	alerted bool
+}
+
+// Create a detector flagging pages with at least minReverts reverts between different users within the window
+func NewEditWarDetector(window time.Duration, minReverts int, classifier *RevertClassifier) *EditWarDetector {
+	if window <= 0 {
+		window = DefaultEditWarWindow
+	}
+	if minReverts <= 0 {
+		minReverts = DefaultEditWarReverts
+	}
+	return &EditWarDetector{
+		window:     window,
+		minReverts: minReverts,
+		classifier: classifier,
+		pages:      make(map[pageKey]*pageHistory),
+	}
+}
+
+// Notify when pages start and stop being at war
+func (d *EditWarDetector) WithNotifier(notifier Notifier) *EditWarDetector {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.notifier = notifier
+	return d
+}
+
+func (d *EditWarDetector) HandleEvent(event models.Event) {
+	if event.Type != "edit" {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if event.Time.After(d.latest) {
+		d.latest = event.Time
+	}
+	key := pageKey{wiki: event.Wiki, title: event.Title}
+	history, ok := d.pages[key]
+	if !ok {
+		history = &pageHistory{}
+		d.pages[key] = history
+	}
+	history.add(pageEdit{
+		user:        event.User,
+		time:        event.Time,
+		oldLength:   event.OldLength,
+		newLength:   event.NewLength,
+		newRevision: event.NewRevision,
+		revert:      d.classifier.Classify(event) != NotRevert,
+	})
+	history.prune(d.latest.Add(-d.window))
+	d.notify(key, history)
+
+	// Drop pages that have gone quiet so memory only holds recently edited pages
+	if d.latest.Sub(d.lastSweep) >= d.window {
+		d.lastSweep = d.latest
+		for key, history := range d.pages {
+			history.prune(d.latest.Add(-d.window))
+			d.notify(key, history)
+			if len(history.edits) == 0 {
+				delete(d.pages, key)
+			}
+		}
+	}
+}
+
+// Send an alert when the page starts or stops being at war. Caller must hold the lock
+func (d *EditWarDetector) notify(key pageKey, history *pageHistory) {
+	if d.notifier == nil {
+		return
+	}
+	war := history.war(d.latest.Add(-d.window))
+	atWar := war.Reverts >= d.minReverts && len(war.Participants) >= 2
+	if atWar == history.alerted {
+		return
+	}
+	history.alerted = atWar
+	war.Wiki = key.wiki
+	war.Title = key.title
+	alert := models.Alert{
+		Rule:    "edit-war/" + key.wiki + "/" + key.title,
+		Source:  "edit_war",
+		State:   models.Firing,
+		Time:    d.latest,
+		Summary: fmt.Sprintf("edit war on %s %s with %d reverts", key.wiki, key.title, war.Reverts),
+		Details: war,
+	}
+	if !atWar {
+		alert.State = models.Resolved
+		alert.Summary = fmt.Sprintf("edit war on %s %s ended", key.wiki, key.title)
+	}
+	d.notifier.Notify(alert)
+}
+
+// Pages currently at war, most reverted first
+func (d *EditWarDetector) ActiveWars() []EditWar {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	wars := make([]EditWar, 0)
+	cutoff := d.latest.Add(-d.window)
+	for key, history := range d.pages {
+		war := history.war(cutoff)
+		if war.Reverts < d.minReverts || len(war.Participants) < 2 {
+			continue
+		}
+		war.Wiki = key.wiki
+		war.Title = key.title
+		wars = append(wars, war)
+	}
+	slices.SortFunc(wars, func(a, b EditWar) int {
+		if order := cmp.Compare(b.Reverts, a.Reverts); order != 0 {
+			return order
+		}
+		return b.LastActivity.Compare(a.LastActivity)
+	})
+	return wars
+}
+
+// Insert the edit in revision order, since the stream can deliver edits slightly out of order
+func (h *pageHistory) add(edit pageEdit) {
+	i, _ := slices.BinarySearchFunc(h.edits, edit, func(a, b pageEdit) int {
+		return cmp.Compare(a.newRevision, b.newRevision)
+	})
+	h.edits = slices.Insert(h.edits, i, edit)
+	if len(h.edits) > maxPageHistory {
+		h.edits = slices.Delete(h.edits, 0, len(h.edits)-maxPageHistory)
+	}
+}
+
+func (h *pageHistory) prune(cutoff time.Time) {
+	h.edits = slices.DeleteFunc(h.edits, func(edit pageEdit) bool {
+		return edit.time.Before(cutoff)
+	})
+}
+
+// Summarize the edits since the cutoff, counting an edit as a revert of another user when it
+// is marked as one or restores the page to the size it had before an earlier edit by someone else
+func (h *pageHistory) war(cutoff time.Time) EditWar {
+	war := EditWar{Participants: make(map[string]int)}
+	for i, edit := range h.edits {
+		if edit.time.Before(cutoff) {
+			continue
+		}
+		war.Edits++
+		war.Participants[edit.user]++
+		if war.Started.IsZero() || edit.time.Before(war.Started) {
+			war.Started = edit.time
+		}
+		if edit.time.After(war.LastActivity) {
+			war.LastActivity = edit.time
+		}
+		if i == 0 || h.edits[i-1].user == edit.user {
+			continue
+		}
+		if edit.revert || restoresEarlierSize(h.edits[:i], edit) {
+			war.Reverts++
+		}
+	}
+	return war
+}
+
+func restoresEarlierSize(earlier []pageEdit, edit pageEdit) bool {
+	if edit.newLength == edit.oldLength {
+		return false
+	}
+	for _, previous := range earlier {
+		if previous.user != edit.user && previous.oldLength == edit.newLength && previous.newLength != previous.oldLength {
+			return true
+		}
+	}
+	return false
+}
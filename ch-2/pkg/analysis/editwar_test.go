@@ -0,0 +1,191 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+	"wikistats/pkg/models"
+)
+
+var start = time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+
+type editArgs struct {
+	minute    int
+	user      string
+	comment   string
+	oldLength int
+	newLength int
+}
+
+func edits(title string, args ...editArgs) []models.Event {
+	events := make([]models.Event, 0, len(args))
+	for i, arg := range args {
+		events = append(events, models.Event{
+			Type:        "edit",
+			Time:        start.Add(time.Duration(arg.minute) * time.Minute),
+			Wiki:        "enwiki",
+			Title:       title,
+			User:        arg.user,
+			Comment:     arg.comment,
+			OldLength:   arg.oldLength,
+			NewLength:   arg.newLength,
+			OldRevision: 100 + i,
+			NewRevision: 101 + i,
+		})
+	}
+	return events
+}
+
+func TestActiveWars(t *testing.T) {
+	tests := []struct {
+		name        string
+		events      []models.Event
+		wantWars    int
+		wantReverts int
+	}{
+		{
+			name: "Reverts marked in comments",
+			events: edits("Go",
+				editArgs{minute: 0, user: "alice", comment: "expand history", oldLength: 100, newLength: 200},
+				editArgs{minute: 1, user: "bob", comment: "Undid revision 101 by alice", oldLength: 200, newLength: 150},
+				editArgs{minute: 2, user: "alice", comment: "Reverted edits by bob", oldLength: 150, newLength: 210},
+				editArgs{minute: 3, user: "bob", comment: "rv unsourced", oldLength: 210, newLength: 140},
+			),
+			wantWars:    1,
+			wantReverts: 3,
+		},
+		{
+			name: "Rollback summaries",
+			events: edits("Go",
+				editArgs{minute: 0, user: "alice", comment: "expand history", oldLength: 100, newLength: 200},
+				editArgs{minute: 1, user: "bob", comment: "Reverted edits by alice (talk) to last revision by carol", oldLength: 200, newLength: 150},
+				editArgs{minute: 2, user: "alice", comment: "Reverted 2 edits by bob (talk) to last version by alice", oldLength: 150, newLength: 210},
+				editArgs{minute: 3, user: "bob", comment: "Undo unsourced claims", oldLength: 210, newLength: 140},
+			),
+			wantWars:    1,
+			wantReverts: 3,
+		},
+		{
+			name: "Comments mentioning reverts in passing",
+			events: edits("Go",
+				editArgs{minute: 0, user: "alice", comment: "expand history", oldLength: 100, newLength: 200},
+				editArgs{minute: 1, user: "bob", comment: "fix typo from revert", oldLength: 200, newLength: 150},
+				editArgs{minute: 2, user: "alice", comment: "copyedit, no need to rv", oldLength: 150, newLength: 210},
+				editArgs{minute: 3, user: "bob", comment: "add source after undid revision", oldLength: 210, newLength: 140},
+			),
+			wantWars: 0,
+		},
+		{
+			name: "Reverts detected from restored page sizes",
+			events: edits("Go",
+				editArgs{minute: 0, user: "alice", oldLength: 100, newLength: 200},
+				editArgs{minute: 1, user: "bob", oldLength: 200, newLength: 100},
+				editArgs{minute: 2, user: "alice", oldLength: 100, newLength: 200},
+				editArgs{minute: 3, user: "bob", oldLength: 200, newLength: 100},
+			),
+			wantWars:    1,
+			wantReverts: 3,
+		},
+		{
+			name: "Collaborative edits",
+			events: edits("Go",
+				editArgs{minute: 0, user: "alice", oldLength: 100, newLength: 200},
+				editArgs{minute: 1, user: "bob", oldLength: 200, newLength: 250},
+				editArgs{minute: 2, user: "alice", oldLength: 250, newLength: 300},
+				editArgs{minute: 3, user: "bob", oldLength: 300, newLength: 320},
+			),
+			wantWars: 0,
+		},
+		{
+			name: "Single user reverting themselves",
+			events: edits("Go",
+				editArgs{minute: 0, user: "alice", oldLength: 100, newLength: 200},
+				editArgs{minute: 1, user: "alice", comment: "revert", oldLength: 200, newLength: 100},
+				editArgs{minute: 2, user: "alice", comment: "revert", oldLength: 100, newLength: 200},
+				editArgs{minute: 3, user: "alice", comment: "revert", oldLength: 200, newLength: 100},
+			),
+			wantWars: 0,
+		},
+		{
+			name: "Reverts spread beyond the window",
+			events: edits("Go",
+				editArgs{minute: 0, user: "alice", comment: "revert", oldLength: 100, newLength: 200},
+				editArgs{minute: 30, user: "bob", comment: "revert", oldLength: 200, newLength: 100},
+				editArgs{minute: 60, user: "alice", comment: "revert", oldLength: 100, newLength: 200},
+				editArgs{minute: 90, user: "bob", comment: "revert", oldLength: 200, newLength: 100},
+			),
+			wantWars: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := NewEditWarDetector(30*time.Minute, 3, NewRevertClassifier())
+			for _, event := range tt.events {
+				detector.HandleEvent(event)
+			}
+			wars := detector.ActiveWars()
+			if len(wars) != tt.wantWars {
+				t.Fatalf("wars: got %+v, want %d", wars, tt.wantWars)
+			}
+			if tt.wantWars == 0 {
+				return
+			}
+			if wars[0].Reverts != tt.wantReverts {
+				t.Errorf("reverts: got %d, want %d", wars[0].Reverts, tt.wantReverts)
+			}
+			if wars[0].Participants["alice"] != 2 || wars[0].Participants["bob"] != 2 {
+				t.Errorf("participants: got %v", wars[0].Participants)
+			}
+		})
+	}
+}
+
+func TestEditWarsExpire(t *testing.T) {
+	detector := NewEditWarDetector(30*time.Minute, 2, NewRevertClassifier())
+	for _, event := range edits("Go",
+		editArgs{minute: 0, user: "alice", comment: "revert"},
+		editArgs{minute: 1, user: "bob", comment: "revert"},
+		editArgs{minute: 2, user: "alice", comment: "revert"},
+	) {
+		detector.HandleEvent(event)
+	}
+	if len(detector.ActiveWars()) != 1 {
+		t.Fatal("War not detected")
+	}
+	// Activity elsewhere moves time forward past the window
+	detector.HandleEvent(edits("Rust", editArgs{minute: 45, user: "corey"})[0])
+	if wars := detector.ActiveWars(); len(wars) != 0 {
+		t.Errorf("War still active after window: %+v", wars)
+	}
+	if _, ok := detector.pages[pageKey{wiki: "enwiki", title: "Go"}]; ok {
+		t.Error("Quiet page not swept")
+	}
+}
+
+type recordingNotifier struct {
+	alerts []models.Alert
+}
+
+func (n *recordingNotifier) Notify(alert models.Alert) {
+	n.alerts = append(n.alerts, alert)
+}
+
+func TestEditWarNotifications(t *testing.T) {
+	notifier := &recordingNotifier{}
+	detector := NewEditWarDetector(30*time.Minute, 2, NewRevertClassifier()).WithNotifier(notifier)
+	for _, event := range edits("Go",
+		editArgs{minute: 0, user: "alice", comment: "revert"},
+		editArgs{minute: 1, user: "bob", comment: "revert"},
+		editArgs{minute: 2, user: "alice", comment: "revert"},
+		editArgs{minute: 3, user: "bob", comment: "revert"},
+	) {
+		detector.HandleEvent(event)
+	}
+	if len(notifier.alerts) != 1 || notifier.alerts[0].State != models.Firing || notifier.alerts[0].Rule != "edit-war/enwiki/Go" {
+		t.Fatalf("alerts: got %+v, want one firing alert", notifier.alerts)
+	}
+	detector.HandleEvent(edits("Rust", editArgs{minute: 45, user: "corey"})[0])
+	if len(notifier.alerts) != 2 || notifier.alerts[1].State != models.Resolved {
+		t.Errorf("alerts: got %+v, want a resolved alert", notifier.alerts)
+	}
+}
@@ -0,0 +1,8 @@
+package analysis
+
+import "wikistats/pkg/models"
+
+// Notifier is told when a detector raises or resolves an alert, and must not block
+type Notifier interface {
+	Notify(alert models.Alert)
+}
@@ -0,0 +1,278 @@
+package analysis
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"wikistats/pkg/models"
+)
+
+type RevertKind string
+
+const (
+	NotRevert RevertKind = ""
+	Rollback  RevertKind = "rollback"
+	Undo      RevertKind = "undo"
+	Revert    RevertKind = "revert"
+)
+
+// Patterns under this language are checked for every wiki after its own language
+const defaultLanguage = "default"
+
+// Fraction of the user TTL between sweeps for users to forget
+const revertSweepFraction = 10
+
+// Kinds in the order they are checked, since rollback summaries also read as generic reverts
+var revertKinds = []RevertKind{Rollback, Undo, Revert}
+
+// Edit summaries left by MediaWiki's undo and rollback features and common revert tools
+var defaultRevertPatterns = map[string]map[RevertKind][]string{
+	defaultLanguage: {
+		Rollback: {`(?i)^reverted \d* ?edits? by .* to (the )?last (revision|version)`},
+		Undo:     {`(?i)^undid revision \d+`, `(?i)^undo\b`},
+		Revert:   {`(?i)^(revert(ed)?|rv|rvv)\b`, `(?i)^restored revision \d+`},
+	},
+	"de": {
+		Rollback: {`(?i)^änderungen von .* rückgängig gemacht und letzte version von .* wiederhergestellt`},
+		Undo:     {`(?i)^änderung \d+ von .* rückgängig gemacht`},
+		Revert:   {`(?i)^(revert|zurückgesetzt)\b`},
+	},
+	"fr": {
+		Rollback: {`(?i)^révocation des modifications de`},
+		Undo:     {`(?i)^annulation de la modification \d+`},
+		Revert:   {`(?i)^(révoqué|revert)\b`},
+	},
+	"es": {
+		Rollback: {`(?i)^revertidos los cambios de .* a la última edición de`},
+		Undo:     {`(?i)^deshecha la edición \d+`},
+		Revert:   {`(?i)^(revertid[oa]s?|revert)\b`},
+	},
+}
+
+// RevertClassifier tags edits as rollbacks, undos or other reverts from their edit summaries
+type RevertClassifier struct {
+	patterns map[string]map[RevertKind][]*regexp.Regexp
+}
+
+// Create a classifier using the built in patterns for English, German, French and Spanish wikis
+func NewRevertClassifier() *RevertClassifier {
+	classifier, err := newRevertClassifier(nil)
+	if err != nil {
+		panic(err)
+	}
+	return classifier
+}
+
+// Create a classifier from a JSON file of the form {"de": {"undo": ["^Änderung \\d+"]}}, where each
+// language's patterns replace the built in patterns for that language and kind
+func LoadRevertClassifier(filename string) (*RevertClassifier, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]map[RevertKind][]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	return newRevertClassifier(overrides)
+}
+
+func newRevertClassifier(overrides map[string]map[RevertKind][]string) (*RevertClassifier, error) {
+	classifier := &RevertClassifier{patterns: make(map[string]map[RevertKind][]*regexp.Regexp)}
+	for _, source := range []map[string]map[RevertKind][]string{defaultRevertPatterns, overrides} {
+		for language, kinds := range source {
+			if classifier.patterns[language] == nil {
+				classifier.patterns[language] = make(map[RevertKind][]*regexp.Regexp)
+			}
+			for kind, patterns := range kinds {
+				if !slices.Contains(revertKinds, kind) {
+					return nil, fmt.Errorf("unknown revert kind %q for language %s", kind, language)
+				}
+				compiled := make([]*regexp.Regexp, 0, len(patterns))
+				for _, pattern := range patterns {
+					re, err := regexp.Compile(pattern)
+					if err != nil {
+						return nil, fmt.Errorf("compiling %s %s pattern: %w", language, kind, err)
+					}
+					compiled = append(compiled, re)
+				}
+				classifier.patterns[language][kind] = compiled
+			}
+		}
+	}
+	return classifier, nil
+}
+
+func (c *RevertClassifier) Classify(event models.Event) RevertKind {
+	if !event.IsEdit() || event.Comment == "" {
+		return NotRevert
+	}
+	for _, language := range []string{language(event), defaultLanguage} {
+		for _, kind := range revertKinds {
+			for _, re := range c.patterns[language][kind] {
+				if re.MatchString(event.Comment) {
+					return kind
+				}
+			}
+		}
+	}
+	return NotRevert
+}
+
+// Language code of the wiki, taken from the subdomain such as de in https://de.wikipedia.org
+func language(event models.Event) string {
+	if u, err := url.Parse(event.Server); err == nil && u.Host != "" {
+		subdomain, _, _ := strings.Cut(u.Host, ".")
+		return subdomain
+	}
+	return ""
+}
+
+// Edit and revert counts for a wiki or user
+type RevertCounts struct {
+	Edits     int     `json:"edits"`
+	Reverts   int     `json:"reverts"`
+	Rollbacks int     `json:"rollbacks"`
+	Undos     int     `json:"undos"`
+	Other     int     `json:"other_reverts"`
+	Rate      float64 `json:"revert_rate"`
+}
+
+func (c *RevertCounts) add(kind RevertKind) {
+	c.Edits++
+	switch kind {
+	case Rollback:
+		c.Rollbacks++
+	case Undo:
+		c.Undos++
+	case Revert:
+		c.Other++
+	}
+	if kind != NotRevert {
+		c.Reverts++
+	}
+	c.Rate = float64(c.Reverts) / float64(c.Edits)
+}
+
+// A user and the reverts they have made
+type UserReverts struct {
+	User string `json:"user"`
+	RevertCounts
+}
+
+// Revert counts for every wiki and the users making the most reverts
+type RevertSummary struct {
+	Wikis map[string]RevertCounts `json:"wikis"`
+	Users []UserReverts           `json:"users"`
+}
+
+// RevertTracker classifies every edit and counts reverts per wiki and per user
+type RevertTracker struct {
+	lock       sync.Mutex
+	classifier *RevertClassifier
+	wikis      map[string]*RevertCounts
+	users      map[string]*RevertCounts
+	// When each user last edited, for forgetting users not seen within the TTL
+	lastSeen  map[string]time.Time
+	userTTL   time.Duration
+	latest    time.Time
+	lastSweep time.Time
+}
+
+func NewRevertTracker(classifier *RevertClassifier) *RevertTracker {
+	return &RevertTracker{
+		classifier: classifier,
+		wikis:      make(map[string]*RevertCounts),
+		users:      make(map[string]*RevertCounts),
+		lastSeen:   make(map[string]time.Time),
+	}
+}
+
+// Forget the counts of users who haven't edited within the TTL. Users are kept forever without a TTL
+func (t *RevertTracker) WithUserTTL(ttl time.Duration) *RevertTracker {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if ttl > 0 {
+		t.userTTL = ttl
+	}
+	return t
+}
+
+func (t *RevertTracker) HandleEvent(event models.Event) {
+	if !event.IsEdit() {
+		return
+	}
+	kind := t.classifier.Classify(event)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	increment(t.wikis, event.Wiki, kind)
+	increment(t.users, event.User, kind)
+	if event.Time.After(t.latest) {
+		t.latest = event.Time
+	}
+	if last, ok := t.lastSeen[event.User]; !ok || event.Time.After(last) {
+		t.lastSeen[event.User] = event.Time
+	}
+	t.expireUsers()
+}
+
+// Sweep only every so often since it visits every user. Caller must hold the lock
+func (t *RevertTracker) expireUsers() {
+	if t.userTTL <= 0 || t.latest.Sub(t.lastSweep) < t.userTTL/revertSweepFraction {
+		return
+	}
+	t.lastSweep = t.latest
+	cutoff := t.latest.Add(-t.userTTL)
+	for user, last := range t.lastSeen {
+		if last.Before(cutoff) {
+			delete(t.lastSeen, user)
+			delete(t.users, user)
+		}
+	}
+}
+
+func increment(counts map[string]*RevertCounts, key string, kind RevertKind) {
+	if counts[key] == nil {
+		counts[key] = &RevertCounts{}
+	}
+	counts[key].add(kind)
+}
+
+// Counts for every wiki and for the users with the most reverts, at most limit when limit is positive
+func (t *RevertTracker) Summary(limit int) RevertSummary {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	summary := RevertSummary{
+		Wikis: make(map[string]RevertCounts, len(t.wikis)),
+		Users: make([]UserReverts, 0),
+	}
+	for wiki, counts := range t.wikis {
+		summary.Wikis[wiki] = *counts
+	}
+	for user, counts := range t.users {
+		if counts.Reverts > 0 {
+			summary.Users = append(summary.Users, UserReverts{User: user, RevertCounts: *counts})
+		}
+	}
+	slices.SortFunc(summary.Users, func(a, b UserReverts) int {
+		if order := cmp.Compare(b.Reverts, a.Reverts); order != 0 {
+			return order
+		}
+		return strings.Compare(a.User, b.User)
+	})
+	if limit > 0 && len(summary.Users) > limit {
+		summary.Users = summary.Users[:limit]
+	}
+	return summary
+}
@@ -0,0 +1,125 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wikistats/pkg/models"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		comment string
+		want    RevertKind
+	}{
+		{name: "English rollback", server: "https://en.wikipedia.org", comment: "Reverted edits by [[Special:Contributions/Alice|Alice]] ([[User talk:Alice|talk]]) to last revision by Bob", want: Rollback},
+		{name: "English tool rollback", server: "https://en.wikipedia.org", comment: "Reverted 2 edits by Alice (talk) to last revision by Bob", want: Rollback},
+		{name: "English undo", server: "https://en.wikipedia.org", comment: "Undid revision 1234 by Alice (talk)", want: Undo},
+		{name: "English manual revert", server: "https://en.wikipedia.org", comment: "rv vandalism", want: Revert},
+		{name: "German undo", server: "https://de.wikipedia.org", comment: "Änderung 1234 von Alice rückgängig gemacht; letzte Version von Bob wiederhergestellt", want: Undo},
+		{name: "German rollback", server: "https://de.wikipedia.org", comment: "Änderungen von Alice rückgängig gemacht und letzte Version von Bob wiederhergestellt", want: Rollback},
+		{name: "English summary on German wiki", server: "https://de.wikipedia.org", comment: "Undid revision 1234 by Alice", want: Undo},
+		{name: "French undo", server: "https://fr.wikipedia.org", comment: "Annulation de la modification 1234 de Alice", want: Undo},
+		{name: "German summary on French wiki", server: "https://fr.wikipedia.org", comment: "Änderung 1234 von Alice rückgängig gemacht", want: NotRevert},
+		{name: "Ordinary edit", server: "https://en.wikipedia.org", comment: "Fixed typo in revert section", want: NotRevert},
+		{name: "Empty comment", server: "https://en.wikipedia.org", comment: "", want: NotRevert},
+	}
+
+	classifier := NewRevertClassifier()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := models.Event{Type: "edit", Server: tt.server, Comment: tt.comment}
+			if got := classifier.Classify(event); got != tt.want {
+				t.Errorf("Classify() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRevertClassifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		wantErr  bool
+	}{
+		{name: "Valid patterns", contents: `{"nl": {"undo": ["(?i)^versie \\d+ van .* ongedaan gemaakt"]}}`},
+		{name: "Invalid JSON", contents: `{"nl": `, wantErr: true},
+		{name: "Unknown kind", contents: `{"nl": {"vandalism": ["x"]}}`, wantErr: true},
+		{name: "Invalid pattern", contents: `{"nl": {"undo": ["("]}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "patterns.json")
+			if err := os.WriteFile(filename, []byte(tt.contents), 0o600); err != nil {
+				t.Fatalf("Error writing patterns: %v", err)
+			}
+			classifier, err := LoadRevertClassifier(filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadRevertClassifier() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			event := models.Event{Type: "edit", Server: "https://nl.wikipedia.org", Comment: "Versie 1234 van Alice ongedaan gemaakt"}
+			if got := classifier.Classify(event); got != Undo {
+				t.Errorf("Classify() = %q, want %q", got, Undo)
+			}
+		})
+	}
+}
+
+func TestRevertSummary(t *testing.T) {
+	tracker := NewRevertTracker(NewRevertClassifier())
+	events := []models.Event{
+		{Type: "edit", Wiki: "enwiki", Server: "https://en.wikipedia.org", User: "alice", Comment: "expand"},
+		{Type: "edit", Wiki: "enwiki", Server: "https://en.wikipedia.org", User: "bob", Comment: "Undid revision 1 by alice"},
+		{Type: "edit", Wiki: "enwiki", Server: "https://en.wikipedia.org", User: "bob", Comment: "rv"},
+		{Type: "edit", Wiki: "enwiki", Server: "https://en.wikipedia.org", User: "corey", Comment: "Reverted edits by alice to last revision by bob"},
+		{Type: "log", Wiki: "enwiki", Server: "https://en.wikipedia.org", User: "corey", Comment: "revert"},
+		{Type: "edit", Wiki: "dewiki", Server: "https://de.wikipedia.org", User: "alice", Comment: "Quellen"},
+	}
+	for _, event := range events {
+		tracker.HandleEvent(event)
+	}
+
+	summary := tracker.Summary(0)
+	enwiki := summary.Wikis["enwiki"]
+	if enwiki.Edits != 4 || enwiki.Reverts != 3 || enwiki.Undos != 1 || enwiki.Rollbacks != 1 || enwiki.Other != 1 {
+		t.Errorf("enwiki: got %+v", enwiki)
+	}
+	if enwiki.Rate != 0.75 {
+		t.Errorf("enwiki rate: got %v, want 0.75", enwiki.Rate)
+	}
+	if dewiki := summary.Wikis["dewiki"]; dewiki.Edits != 1 || dewiki.Reverts != 0 {
+		t.Errorf("dewiki: got %+v", dewiki)
+	}
+	if len(summary.Users) != 2 || summary.Users[0].User != "bob" || summary.Users[0].Reverts != 2 || summary.Users[1].User != "corey" {
+		t.Errorf("users: got %+v, want bob then corey", summary.Users)
+	}
+	if limited := tracker.Summary(1); len(limited.Users) != 1 {
+		t.Errorf("Limit not applied: %+v", limited.Users)
+	}
+}
+
+func TestRevertUserTTL(t *testing.T) {
+	tracker := NewRevertTracker(NewRevertClassifier()).WithUserTTL(time.Hour)
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	tracker.HandleEvent(models.Event{Type: "edit", Time: start, Wiki: "enwiki", User: "alice", Comment: "rv"})
+	tracker.HandleEvent(models.Event{Type: "edit", Time: start.Add(30 * time.Minute), Wiki: "enwiki", User: "bob", Comment: "rv"})
+	if users := tracker.Summary(0).Users; len(users) != 2 {
+		t.Errorf("users: got %+v, want alice and bob", users)
+	}
+	tracker.HandleEvent(models.Event{Type: "edit", Time: start.Add(80 * time.Minute), Wiki: "enwiki", User: "corey", Comment: "expand"})
+	summary := tracker.Summary(0)
+	if len(summary.Users) != 1 || summary.Users[0].User != "bob" {
+		t.Errorf("users: got %+v, want bob", summary.Users)
+	}
+	// Wiki counts are kept
+	if enwiki := summary.Wikis["enwiki"]; enwiki.Edits != 3 || enwiki.Reverts != 2 {
+		t.Errorf("enwiki: got %+v", enwiki)
+	}
+}
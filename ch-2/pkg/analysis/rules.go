@@ -0,0 +1,333 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"wikistats/pkg/database"
+	"wikistats/pkg/models"
+)
+
+const DefaultRulesInterval = time.Minute
+
+// Metrics rules can compare, each measured over the time since the previous evaluation
+var ruleMetrics = map[string]func(delta database.WikiCounts, elapsed time.Duration) (float64, bool){
+	"events_per_minute": func(delta database.WikiCounts, elapsed time.Duration) (float64, bool) {
+		return float64(delta.Events) / elapsed.Minutes(), true
+	},
+	"edits_per_minute": func(delta database.WikiCounts, elapsed time.Duration) (float64, bool) {
+		return float64(delta.Edits) / elapsed.Minutes(), true
+	},
+	"human_edits_per_minute": func(delta database.WikiCounts, elapsed time.Duration) (float64, bool) {
+		return float64(delta.HumanEdits) / elapsed.Minutes(), true
+	},
+	"bot_edits_per_minute": func(delta database.WikiCounts, elapsed time.Duration) (float64, bool) {
+		return float64(delta.BotEdits) / elapsed.Minutes(), true
+	},
+	// Percentage of edits made by bots, undefined without edits
+	"bot_share": func(delta database.WikiCounts, elapsed time.Duration) (float64, bool) {
+		if delta.Edits == 0 {
+			return 0, false
+		}
+		return 100 * float64(delta.BotEdits) / float64(delta.Edits), true
+	},
+}
+
+var ruleOperators = map[string]func(value float64, threshold float64) bool{
+	"<":  func(value float64, threshold float64) bool { return value < threshold },
+	"<=": func(value float64, threshold float64) bool { return value <= threshold },
+	">":  func(value float64, threshold float64) bool { return value > threshold },
+	">=": func(value float64, threshold float64) bool { return value >= threshold },
+}
+
+// Duration read from JSON strings such as "5m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// A rule such as {"name": "enwiki-quiet", "wiki": "enwiki", "metric": "human_edits_per_minute", "op": "<",
+// "value": 50, "for": "5m"}, which fires once the comparison has held for the duration. Rules without a
+// wiki apply to the totals across every wiki
+type ThresholdRule struct {
+	Name   string   `json:"name"`
+	Wiki   string   `json:"wiki,omitempty"`
+	Metric string   `json:"metric"`
+	Op     string   `json:"op"`
+	Value  float64  `json:"value"`
+	For    Duration `json:"for,omitempty"`
+}
+
+func (r ThresholdRule) String() string {
+	wiki := r.Wiki
+	if wiki == "" {
+		wiki = "all wikis"
+	}
+	return fmt.Sprintf("%s %s %s %g for %s", wiki, r.Metric, r.Op, r.Value, time.Duration(r.For))
+}
+
+type RuleState string
+
+const (
+	RuleOK      RuleState = "ok"
+	RulePending RuleState = "pending"
+	RuleFiring  RuleState = "firing"
+)
+
+// A rule and the outcome of its latest evaluation
+type RuleStatus struct {
+	ThresholdRule
+	State RuleState `json:"state"`
+	// When the rule entered its current state
+	Since         time.Time  `json:"since"`
+	LastValue     float64    `json:"last_value"`
+	LastEvaluated time.Time  `json:"last_evaluated"`
+	LastResolved  *time.Time `json:"last_resolved"`
+}
+
+// Read and check the rules in a JSON file holding a list of rules
+func LoadThresholdRules(filename string) ([]ThresholdRule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rules []ThresholdRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	names := make(map[string]struct{}, len(rules))
+	for i, rule := range rules {
+		var problems []string
+		if rule.Name == "" {
+			problems = append(problems, "missing name")
+		}
+		if _, ok := names[rule.Name]; ok {
+			problems = append(problems, fmt.Sprintf("duplicate name %q", rule.Name))
+		}
+		names[rule.Name] = struct{}{}
+		if _, ok := ruleMetrics[rule.Metric]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown metric %q", rule.Metric))
+		}
+		if _, ok := ruleOperators[rule.Op]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown operator %q", rule.Op))
+		}
+		if rule.For < 0 {
+			problems = append(problems, "negative duration")
+		}
+		if len(problems) > 0 {
+			return nil, fmt.Errorf("%s rule %d: %s", filename, i+1, strings.Join(problems, ", "))
+		}
+	}
+	return rules, nil
+}
+
+// RuleEngine periodically evaluates threshold rules against the database's running counts,
+// reloading the rules file whenever it changes
+type RuleEngine struct {
+	lock     sync.Mutex
+	filename string
+	modified time.Time
+	counter  database.WikiCounter
+	notifier Notifier
+	rules    []*RuleStatus
+	previous map[string]database.WikiCounts
+	// Time the previous counts were read
+	previousTime time.Time
+}
+
+func NewRuleEngine(filename string, counter database.WikiCounter) (*RuleEngine, error) {
+	e := &RuleEngine{filename: filename, counter: counter}
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Notify when rules start and stop firing
+func (e *RuleEngine) WithNotifier(notifier Notifier) *RuleEngine {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.notifier = notifier
+	return e
+}
+
+// Evaluate the rules every interval until the context is canceled
+func (e *RuleEngine) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRulesInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := e.reload(); err != nil {
+				log.Printf("Error reloading alert rules, keeping the previous rules: %v", err)
+			}
+			e.evaluate(now)
+		}
+	}
+}
+
+// Load the rules file if it changed since it was last loaded, keeping the state of unchanged rules
+func (e *RuleEngine) reload() error {
+	info, err := os.Stat(e.filename)
+	if err != nil {
+		return err
+	}
+	e.lock.Lock()
+	unchanged := info.ModTime().Equal(e.modified)
+	e.lock.Unlock()
+	if unchanged {
+		return nil
+	}
+	rules, err := LoadThresholdRules(e.filename)
+	if err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	statuses := make([]*RuleStatus, 0, len(rules))
+	for _, rule := range rules {
+		i := slices.IndexFunc(e.rules, func(status *RuleStatus) bool { return status.ThresholdRule == rule })
+		if i >= 0 {
+			statuses = append(statuses, e.rules[i])
+			continue
+		}
+		statuses = append(statuses, &RuleStatus{ThresholdRule: rule, State: RuleOK})
+	}
+	if e.modified.IsZero() {
+		log.Printf("Loaded %d alert rules from %s", len(rules), e.filename)
+	} else {
+		log.Printf("Reloaded %d alert rules from %s", len(rules), e.filename)
+	}
+	e.rules = statuses
+	e.modified = info.ModTime()
+	return nil
+}
+
+// Compare each rule's metric over the time since the previous evaluation with its threshold
+func (e *RuleEngine) evaluate(now time.Time) {
+	counts := e.counter.GetWikiCounts()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	previous, elapsed := e.previous, now.Sub(e.previousTime)
+	e.previous, e.previousTime = counts, now
+	if previous == nil || elapsed <= 0 {
+		return
+	}
+	for _, status := range e.rules {
+		delta := wikiDelta(counts, previous, status.Wiki)
+		value, ok := ruleMetrics[status.Metric](delta, elapsed)
+		matched := ok && ruleOperators[status.Op](value, status.Value)
+		status.LastValue = value
+		status.LastEvaluated = now
+		e.transition(status, matched, now)
+	}
+}
+
+func (e *RuleEngine) transition(status *RuleStatus, matched bool, now time.Time) {
+	switch {
+	case matched && status.State == RuleOK:
+		status.State = RulePending
+		status.Since = now
+		if status.For == 0 {
+			e.fire(status, now)
+		}
+	case matched && status.State == RulePending && now.Sub(status.Since) >= time.Duration(status.For):
+		e.fire(status, now)
+	case !matched && status.State == RuleFiring:
+		status.State = RuleOK
+		status.Since = now
+		status.LastResolved = &now
+		e.notify(status, models.Resolved, now)
+	case !matched && status.State == RulePending:
+		status.State = RuleOK
+		status.Since = now
+	}
+}
+
+func (e *RuleEngine) fire(status *RuleStatus, now time.Time) {
+	status.State = RuleFiring
+	status.Since = now
+	e.notify(status, models.Firing, now)
+}
+
+func (e *RuleEngine) notify(status *RuleStatus, state models.AlertState, now time.Time) {
+	log.Printf("Alert rule %s %s: %s, value %g", status.Name, state, status.ThresholdRule, status.LastValue)
+	if e.notifier == nil {
+		return
+	}
+	e.notifier.Notify(models.Alert{
+		Rule:    "rule/" + status.Name,
+		Source:  "threshold",
+		State:   state,
+		Time:    now,
+		Summary: fmt.Sprintf("%s, value %g", status.ThresholdRule, status.LastValue),
+		Details: *status,
+	})
+}
+
+// Change in a wiki's counts, or in the totals across wikis when wiki is empty
+func wikiDelta(counts map[string]database.WikiCounts, previous map[string]database.WikiCounts, wiki string) database.WikiCounts {
+	var current, before database.WikiCounts
+	if wiki != "" {
+		current, before = counts[wiki], previous[wiki]
+	} else {
+		for _, wikiCounts := range counts {
+			current.Add(wikiCounts)
+		}
+		for _, wikiCounts := range previous {
+			before.Add(wikiCounts)
+		}
+	}
+	return database.WikiCounts{
+		Events:     current.Events - before.Events,
+		Edits:      current.Edits - before.Edits,
+		HumanEdits: current.HumanEdits - before.HumanEdits,
+		BotEdits:   current.BotEdits - before.BotEdits,
+	}
+}
+
+// Every rule and its state, firing rules first
+func (e *RuleEngine) Rules() []RuleStatus {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	order := map[RuleState]int{RuleFiring: 0, RulePending: 1, RuleOK: 2}
+	statuses := make([]RuleStatus, 0, len(e.rules))
+	for _, status := range e.rules {
+		statuses = append(statuses, *status)
+	}
+	slices.SortStableFunc(statuses, func(a, b RuleStatus) int {
+		return order[a.State] - order[b.State]
+	})
+	return statuses
+}
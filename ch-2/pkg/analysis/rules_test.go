@@ -0,0 +1,139 @@
+package analysis
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wikistats/pkg/database"
+	"wikistats/pkg/models"
+)
+
+type fakeCounter struct {
+	counts map[string]database.WikiCounts
+}
+
+func (c *fakeCounter) GetWikiCounts() map[string]database.WikiCounts {
+	return maps.Clone(c.counts)
+}
+
+// Add a minute of edits to the counts
+func (c *fakeCounter) add(wiki string, humanEdits int, botEdits int) {
+	counts := c.counts[wiki]
+	counts.Add(database.WikiCounts{Events: humanEdits + botEdits, Edits: humanEdits + botEdits, HumanEdits: humanEdits, BotEdits: botEdits})
+	c.counts[wiki] = counts
+}
+
+func writeRules(t *testing.T, filename string, contents string, modified time.Time) {
+	t.Helper()
+	if err := os.WriteFile(filename, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Error writing rules: %v", err)
+	}
+	if err := os.Chtimes(filename, modified, modified); err != nil {
+		t.Fatalf("Error setting modification time: %v", err)
+	}
+}
+
+func ruleStates(engine *RuleEngine) map[string]RuleState {
+	states := make(map[string]RuleState)
+	for _, status := range engine.Rules() {
+		states[status.Name] = status.State
+	}
+	return states
+}
+
+func TestRuleEngine(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rules.json")
+	writeRules(t, filename, `[
+		{"name": "enwiki-quiet", "wiki": "enwiki", "metric": "human_edits_per_minute", "op": "<", "value": 50, "for": "3m"},
+		{"name": "bot-share", "metric": "bot_share", "op": ">", "value": 80}
+	]`, start)
+	counter := &fakeCounter{counts: make(map[string]database.WikiCounts)}
+	notifier := &recordingNotifier{}
+	engine, err := NewRuleEngine(filename, counter)
+	if err != nil {
+		t.Fatalf("NewRuleEngine() error = %v", err)
+	}
+	engine.WithNotifier(notifier)
+
+	steps := []struct {
+		humanEdits int
+		botEdits   int
+		want       map[string]RuleState
+	}{
+		{humanEdits: 100, botEdits: 10, want: map[string]RuleState{"enwiki-quiet": RuleOK, "bot-share": RuleOK}},
+		{humanEdits: 20, botEdits: 10, want: map[string]RuleState{"enwiki-quiet": RulePending, "bot-share": RuleOK}},
+		{humanEdits: 20, botEdits: 10, want: map[string]RuleState{"enwiki-quiet": RulePending, "bot-share": RuleOK}},
+		{humanEdits: 10, botEdits: 90, want: map[string]RuleState{"enwiki-quiet": RulePending, "bot-share": RuleFiring}},
+		{humanEdits: 10, botEdits: 10, want: map[string]RuleState{"enwiki-quiet": RuleFiring, "bot-share": RuleOK}},
+		{humanEdits: 100, botEdits: 10, want: map[string]RuleState{"enwiki-quiet": RuleOK, "bot-share": RuleOK}},
+	}
+	now := start
+	engine.evaluate(now)
+	for i, step := range steps {
+		counter.add("enwiki", step.humanEdits, step.botEdits)
+		now = now.Add(time.Minute)
+		engine.evaluate(now)
+		for name, want := range step.want {
+			if got := ruleStates(engine)[name]; got != want {
+				t.Errorf("minute %d %s: got %s, want %s", i+1, name, got, want)
+			}
+		}
+	}
+
+	wantAlerts := []struct {
+		rule  string
+		state models.AlertState
+	}{
+		{rule: "rule/bot-share", state: models.Firing},
+		{rule: "rule/enwiki-quiet", state: models.Firing},
+		{rule: "rule/bot-share", state: models.Resolved},
+		{rule: "rule/enwiki-quiet", state: models.Resolved},
+	}
+	if len(notifier.alerts) != len(wantAlerts) {
+		t.Fatalf("alerts: got %+v, want %d", notifier.alerts, len(wantAlerts))
+	}
+	for i, want := range wantAlerts {
+		if notifier.alerts[i].Rule != want.rule || notifier.alerts[i].State != want.state {
+			t.Errorf("alert %d: got %s %s, want %s %s", i, notifier.alerts[i].Rule, notifier.alerts[i].State, want.rule, want.state)
+		}
+	}
+}
+
+func TestRuleEngineReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rules.json")
+	writeRules(t, filename, `[{"name": "busy", "metric": "edits_per_minute", "op": ">", "value": 10}]`, start)
+	counter := &fakeCounter{counts: make(map[string]database.WikiCounts)}
+	engine, err := NewRuleEngine(filename, counter)
+	if err != nil {
+		t.Fatalf("NewRuleEngine() error = %v", err)
+	}
+	engine.evaluate(start)
+	counter.add("enwiki", 100, 0)
+	engine.evaluate(start.Add(time.Minute))
+	if state := ruleStates(engine)["busy"]; state != RuleFiring {
+		t.Fatalf("busy: got %s, want firing", state)
+	}
+
+	// Unchanged rules keep their state when others are added
+	writeRules(t, filename, `[
+		{"name": "busy", "metric": "edits_per_minute", "op": ">", "value": 10},
+		{"name": "quiet", "wiki": "dewiki", "metric": "edits_per_minute", "op": "<", "value": 1}
+	]`, start.Add(time.Minute))
+	if err := engine.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if states := ruleStates(engine); len(states) != 2 || states["busy"] != RuleFiring || states["quiet"] != RuleOK {
+		t.Errorf("states after reload: got %v", states)
+	}
+
+	// Invalid files are rejected and the loaded rules kept
+	writeRules(t, filename, `[{"name": "broken", "metric": "nonsense", "op": "~"}]`, start.Add(2*time.Minute))
+	if err := engine.reload(); err == nil {
+		t.Error("Expected error for invalid rules")
+	}
+	if states := ruleStates(engine); len(states) != 2 {
+		t.Errorf("states after failed reload: got %v", states)
+	}
+}
@@ -0,0 +1,195 @@
+package analysis
+
+import (
+	"container/list"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	DefaultSessionGap = 30 * time.Minute
+	// In-progress sessions kept before the least recently active are closed early
+	DefaultMaxOpenSessions = 100000
+)
+
+// Upper bounds of the session length histogram buckets
+var sessionBuckets = []time.Duration{0, time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour, 4 * time.Hour}
+
+type SessionBucket struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
+
+// Completed sessions on a wiki, with the number still in progress
+type WikiSessions struct {
+	Sessions        int             `json:"sessions"`
+	Edits           int             `json:"edits"`
+	EditsPerSession float64         `json:"edits_per_session"`
+	MaxEdits        int             `json:"max_edits"`
+	AverageLength   float64         `json:"average_length_seconds"`
+	Lengths         []SessionBucket `json:"lengths"`
+	Open            int             `json:"open"`
+}
+
+type wikiSessionCounts struct {
+	sessions int
+	edits    int
+	maxEdits int
+	length   time.Duration
+	lengths  []int
+	open     int
+}
+
+type sessionKey struct {
+	wiki string
+	user string
+}
+
+type openSession struct {
+	key   sessionKey
+	start time.Time
+	last  time.Time
+	edits int
+}
+
+// SessionTracker groups each user's edits on a wiki into sessions separated by an inactivity gap.
+// Bot edits are skipped since bots edit around the clock
+type SessionTracker struct {
+	lock    sync.Mutex
+	gap     time.Duration
+	maxOpen int
+	// In-progress sessions, least recently active at the front
+	open      *list.List
+	sessions  map[sessionKey]*list.Element
+	wikis     map[string]*wikiSessionCounts
+	latest    time.Time
+	lastSweep time.Time
+}
+
+// Create a tracker ending sessions after gap without an edit and holding at most maxOpen sessions in progress
+func NewSessionTracker(gap time.Duration, maxOpen int) *SessionTracker {
+	if gap <= 0 {
+		gap = DefaultSessionGap
+	}
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenSessions
+	}
+	return &SessionTracker{
+		gap:      gap,
+		maxOpen:  maxOpen,
+		open:     list.New(),
+		sessions: make(map[sessionKey]*list.Element),
+		wikis:    make(map[string]*wikiSessionCounts),
+	}
+}
+
+func (t *SessionTracker) HandleEvent(event models.Event) {
+	if !event.IsEdit() || event.Bot {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if event.Time.After(t.latest) {
+		t.latest = event.Time
+	}
+	key := sessionKey{wiki: event.Wiki, user: event.User}
+	if element, ok := t.sessions[key]; ok {
+		session := element.Value.(*openSession)
+		if event.Time.Sub(session.last) <= t.gap {
+			session.edits++
+			if event.Time.After(session.last) {
+				session.last = event.Time
+			}
+			t.open.MoveToBack(element)
+			t.sweep()
+			return
+		}
+		t.close(element)
+	}
+	t.sessions[key] = t.open.PushBack(&openSession{key: key, start: event.Time, last: event.Time, edits: 1})
+	t.wiki(event.Wiki).open++
+	if t.open.Len() > t.maxOpen {
+		t.close(t.open.Front())
+	}
+	t.sweep()
+}
+
+// Close sessions that have been inactive for longer than the gap, at most once per gap of stream time
+func (t *SessionTracker) sweep() {
+	if t.latest.Sub(t.lastSweep) < t.gap {
+		return
+	}
+	t.lastSweep = t.latest
+	cutoff := t.latest.Add(-t.gap)
+	for element := t.open.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*openSession).last.Before(cutoff) {
+			t.close(element)
+		}
+		element = next
+	}
+}
+
+func (t *SessionTracker) close(element *list.Element) {
+	session := t.open.Remove(element).(*openSession)
+	delete(t.sessions, session.key)
+	counts := t.wiki(session.key.wiki)
+	counts.open--
+	counts.sessions++
+	counts.edits += session.edits
+	counts.maxEdits = max(counts.maxEdits, session.edits)
+	length := session.last.Sub(session.start)
+	counts.length += length
+	bucket, _ := slices.BinarySearch(sessionBuckets, length)
+	counts.lengths[bucket]++
+}
+
+func (t *SessionTracker) wiki(name string) *wikiSessionCounts {
+	counts, ok := t.wikis[name]
+	if !ok {
+		counts = &wikiSessionCounts{lengths: make([]int, len(sessionBuckets)+1)}
+		t.wikis[name] = counts
+	}
+	return counts
+}
+
+// Session stats for every wiki, closing sessions that have gone quiet first
+func (t *SessionTracker) Summary() map[string]WikiSessions {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.sweep()
+	summary := make(map[string]WikiSessions, len(t.wikis))
+	for wiki, counts := range t.wikis {
+		stats := WikiSessions{
+			Sessions: counts.sessions,
+			Edits:    counts.edits,
+			MaxEdits: counts.maxEdits,
+			Lengths:  make([]SessionBucket, 0, len(counts.lengths)),
+			Open:     counts.open,
+		}
+		if counts.sessions > 0 {
+			stats.EditsPerSession = float64(counts.edits) / float64(counts.sessions)
+			stats.AverageLength = counts.length.Seconds() / float64(counts.sessions)
+		}
+		for i, count := range counts.lengths {
+			stats.Lengths = append(stats.Lengths, SessionBucket{Range: sessionRange(i), Count: count})
+		}
+		summary[wiki] = stats
+	}
+	return summary
+}
+
+func sessionRange(i int) string {
+	switch i {
+	case 0:
+		return "0s"
+	case len(sessionBuckets):
+		return fmt.Sprintf("(%s, inf)", sessionBuckets[len(sessionBuckets)-1])
+	}
+	return fmt.Sprintf("(%s, %s]", sessionBuckets[i-1], sessionBuckets[i])
+}
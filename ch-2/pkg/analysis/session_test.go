@@ -0,0 +1,70 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+	"wikistats/pkg/models"
+)
+
+func sessionEdit(wiki string, user string, minute int) models.Event {
+	return models.Event{Type: "edit", Wiki: wiki, User: user, Time: start.Add(time.Duration(minute) * time.Minute)}
+}
+
+func TestSessions(t *testing.T) {
+	tracker := NewSessionTracker(30*time.Minute, 0)
+	for _, event := range []models.Event{
+		// alice has a 20 minute session then a single edit after a break
+		sessionEdit("enwiki", "alice", 0),
+		sessionEdit("enwiki", "alice", 10),
+		sessionEdit("enwiki", "alice", 20),
+		sessionEdit("enwiki", "alice", 90),
+		// Edits on another wiki are a separate session
+		sessionEdit("dewiki", "alice", 15),
+		sessionEdit("enwiki", "bob", 5),
+		{Type: "edit", Wiki: "enwiki", User: "SomeBot", Bot: true, Time: start},
+		{Type: "log", Wiki: "enwiki", User: "corey", Time: start},
+		// Moves stream time on so every earlier session has ended
+		sessionEdit("frwiki", "corey", 200),
+	} {
+		tracker.HandleEvent(event)
+	}
+
+	summary := tracker.Summary()
+	enwiki := summary["enwiki"]
+	if enwiki.Sessions != 3 || enwiki.Edits != 5 || enwiki.MaxEdits != 3 || enwiki.Open != 0 {
+		t.Errorf("enwiki: got %+v, want 3 sessions of 5 edits", enwiki)
+	}
+	if want := 20 * time.Minute.Seconds() / 3; enwiki.AverageLength != want {
+		t.Errorf("average length: got %v, want %v", enwiki.AverageLength, want)
+	}
+	counts := make(map[string]int)
+	for _, bucket := range enwiki.Lengths {
+		counts[bucket.Range] = bucket.Count
+	}
+	if counts["0s"] != 2 || counts["(15m0s, 30m0s]"] != 1 {
+		t.Errorf("lengths: got %v", enwiki.Lengths)
+	}
+	if dewiki := summary["dewiki"]; dewiki.Sessions != 1 || dewiki.Edits != 1 {
+		t.Errorf("dewiki: got %+v, want 1 session", dewiki)
+	}
+	if frwiki := summary["frwiki"]; frwiki.Sessions != 0 || frwiki.Open != 1 {
+		t.Errorf("frwiki: got %+v, want 1 open session", frwiki)
+	}
+}
+
+func TestSessionsBounded(t *testing.T) {
+	tracker := NewSessionTracker(time.Hour, 2)
+	for _, user := range []string{"alice", "bob", "corey", "dana"} {
+		tracker.HandleEvent(sessionEdit("enwiki", user, 0))
+	}
+	if tracker.open.Len() != 2 || len(tracker.sessions) != 2 {
+		t.Fatalf("open sessions: got %d, want 2", tracker.open.Len())
+	}
+	// The least recently active sessions were closed to make room
+	if _, ok := tracker.sessions[sessionKey{wiki: "enwiki", user: "alice"}]; ok {
+		t.Error("Oldest session still open")
+	}
+	if enwiki := tracker.Summary()["enwiki"]; enwiki.Sessions != 2 || enwiki.Open != 2 {
+		t.Errorf("enwiki: got %+v, want 2 closed and 2 open", enwiki)
+	}
+}
@@ -0,0 +1,205 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"wikistats/pkg/database"
+	"wikistats/pkg/models"
+)
+
+// Number of suspicious edits kept for the feed
+const suspiciousFeedSize = 500
+
+// Weights added to an edit's score for each heuristic it trips, and the limits the heuristics use
+type VandalismRules struct {
+	LargeRemoval      int      `json:"large_removal"`
+	LargeRemovalBytes int      `json:"large_removal_bytes"`
+	Blanking          int      `json:"blanking"`
+	BlankingBytes     int      `json:"blanking_bytes"`
+	Anonymous         int      `json:"anonymous"`
+	AllCapsComment    int      `json:"all_caps_comment"`
+	Profanity         int      `json:"profanity"`
+	ProfanityWords    []string `json:"profanity_words"`
+	NewUser           int      `json:"new_user"`
+	// Users are new with fewer earlier edits than this. Profiles only hold edits seen since startup, or
+	// since the snapshot they were restored from, so the rule waits NewUserWarmup seconds after the first
+	// scored edit rather than treating every established editor as new
+	NewUserEdits  int `json:"new_user_edits"`
+	NewUserWarmup int `json:"new_user_warmup"`
+	Threshold     int `json:"threshold"`
+}
+
+func DefaultVandalismRules() VandalismRules {
+	return VandalismRules{
+		LargeRemoval:      30,
+		LargeRemovalBytes: 2000,
+		Blanking:          50,
+		BlankingBytes:     50,
+		Anonymous:         15,
+		AllCapsComment:    15,
+		Profanity:         25,
+		ProfanityWords:    []string{"fuck", "shit", "crap", "poop", "stupid", "idiot", "sucks", "lol"},
+		NewUser:           10,
+		NewUserEdits:      3,
+		NewUserWarmup:     24 * 60 * 60,
+		Threshold:         40,
+	}
+}
+
+// Load rules from a JSON file, where missing fields keep their default values
+func LoadVandalismRules(filename string) (VandalismRules, error) {
+	rules := DefaultVandalismRules()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return VandalismRules{}, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return VandalismRules{}, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// An edit that scored at or above the threshold and the heuristics it tripped
+type SuspiciousEdit struct {
+	Event   models.Event `json:"event"`
+	Score   int          `json:"score"`
+	Reasons []string     `json:"reasons"`
+}
+
+// VandalismScorer scores every edit with offline heuristics and keeps a feed of the suspicious ones
+type VandalismScorer struct {
+	lock     sync.Mutex
+	rules    VandalismRules
+	profiles database.UserProfiler
+	feed     []SuspiciousEdit
+	next     int
+	// Time of the first scored edit, for the new user warm-up
+	started time.Time
+}
+
+// Create a scorer, using profiles to recognize new users when it is not nil
+func NewVandalismScorer(rules VandalismRules, profiles database.UserProfiler) *VandalismScorer {
+	return &VandalismScorer{
+		rules:    rules,
+		profiles: profiles,
+		feed:     make([]SuspiciousEdit, 0, suspiciousFeedSize),
+	}
+}
+
+// Score the edit and the reasons behind the score. The edit is taken to be in the profiles already,
+// as it is once the database has recorded it
+func (s *VandalismScorer) Score(event models.Event) (int, []string) {
+	rules := s.rules
+	score := 0
+	reasons := make([]string, 0)
+	trip := func(weight int, reason string) {
+		score += weight
+		reasons = append(reasons, reason)
+	}
+
+	removed := -event.ByteDelta()
+	if event.OldLength >= rules.BlankingBytes && event.NewLength < rules.BlankingBytes {
+		trip(rules.Blanking, "blanking")
+	} else if removed >= rules.LargeRemovalBytes {
+		trip(rules.LargeRemoval, "large_removal")
+	}
+	if models.ClassifyEditor(event.User) != models.Registered {
+		trip(rules.Anonymous, "anonymous")
+	}
+	if isAllCaps(event.Comment) {
+		trip(rules.AllCapsComment, "all_caps_comment")
+	}
+	if containsProfanity(event.Comment, rules.ProfanityWords) {
+		trip(rules.Profanity, "profanity")
+	}
+	if s.profiles != nil && s.warmedUp(event.Time) {
+		if profile, ok := s.profiles.GetUserProfile(event.User); ok && profile.Edits-1 < rules.NewUserEdits {
+			trip(rules.NewUser, "new_user")
+		}
+	}
+	return score, reasons
+}
+
+// Whether the profiles have been followed for long enough to tell new users from established ones
+func (s *VandalismScorer) warmedUp(at time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.started.IsZero() || at.Before(s.started) {
+		s.started = at
+	}
+	return at.Sub(s.started) >= time.Duration(s.rules.NewUserWarmup)*time.Second
+}
+
+func (s *VandalismScorer) HandleEvent(event models.Event) {
+	if !event.IsEdit() {
+		return
+	}
+	score, reasons := s.Score(event)
+	if score < s.rules.Threshold {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	edit := SuspiciousEdit{Event: event, Score: score, Reasons: reasons}
+	if len(s.feed) < cap(s.feed) {
+		s.feed = append(s.feed, edit)
+		return
+	}
+	s.feed[s.next] = edit
+	s.next = (s.next + 1) % len(s.feed)
+}
+
+// Most recent suspicious edits scoring at least minScore first, at most limit when limit is positive
+func (s *VandalismScorer) Suspicious(minScore int, limit int) []SuspiciousEdit {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	edits := make([]SuspiciousEdit, 0)
+	for i := range len(s.feed) {
+		// Walk backwards from the newest entry in the ring
+		edit := s.feed[(s.next-1-i+2*len(s.feed))%len(s.feed)]
+		if edit.Score < minScore {
+			continue
+		}
+		edits = append(edits, edit)
+		if limit > 0 && len(edits) == limit {
+			break
+		}
+	}
+	return edits
+}
+
+// Whether the comment shouts, ignoring short comments that are likely acronyms
+func isAllCaps(comment string) bool {
+	upper := 0
+	for _, r := range comment {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	return upper >= 8
+}
+
+func containsProfanity(comment string, words []string) bool {
+	fields := strings.FieldsFunc(comment, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, field := range fields {
+		if slices.ContainsFunc(words, func(word string) bool { return strings.EqualFold(word, field) }) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package analysis
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+	"wikistats/pkg/database"
+	"wikistats/pkg/models"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name        string
+		event       models.Event
+		wantReasons []string
+	}{
+		{
+			name:        "Ordinary edit",
+			event:       models.Event{Type: "edit", User: "alice", Comment: "copyedit", OldLength: 5000, NewLength: 5100},
+			wantReasons: []string{},
+		},
+		{
+			name:        "Large removal",
+			event:       models.Event{Type: "edit", User: "alice", Comment: "trim", OldLength: 5000, NewLength: 2000},
+			wantReasons: []string{"large_removal"},
+		},
+		{
+			name:        "Blanking takes precedence over large removal",
+			event:       models.Event{Type: "edit", User: "alice", OldLength: 5000, NewLength: 10},
+			wantReasons: []string{"blanking"},
+		},
+		{
+			name:        "Anonymous IPv6 editor",
+			event:       models.Event{Type: "edit", User: "2001:db8::1", Comment: "fix"},
+			wantReasons: []string{"anonymous"},
+		},
+		{
+			name:        "Temporary account",
+			event:       models.Event{Type: "edit", User: "~2025-12345-67", Comment: "fix"},
+			wantReasons: []string{"anonymous"},
+		},
+		{
+			name:        "Shouting and profanity",
+			event:       models.Event{Type: "edit", User: "192.0.2.1", Comment: "THIS ARTICLE SUCKS"},
+			wantReasons: []string{"anonymous", "all_caps_comment", "profanity"},
+		},
+		{
+			name:        "Short acronyms and uncased scripts are not shouting",
+			event:       models.Event{Type: "edit", User: "alice", Comment: "NPOV 修正"},
+			wantReasons: []string{},
+		},
+		{
+			name:        "New user",
+			event:       models.Event{Type: "edit", User: "newbie", Comment: "first edit"},
+			wantReasons: []string{"new_user"},
+		},
+	}
+
+	db := database.NewInMemoryDatabase()
+	db.RecordEvent(models.Event{ID: "1", Type: "edit", User: "newbie"})
+	for i := range 10 {
+		db.RecordEvent(models.Event{ID: string(rune('a' + i)), Type: "edit", User: "alice"})
+	}
+	rules := DefaultVandalismRules()
+	rules.NewUserWarmup = 0
+	scorer := NewVandalismScorer(rules, db)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, reasons := scorer.Score(tt.event)
+			if !slices.Equal(reasons, tt.wantReasons) {
+				t.Errorf("reasons: got %v, want %v", reasons, tt.wantReasons)
+			}
+			if len(reasons) == 0 && score != 0 {
+				t.Errorf("score: got %d without any reasons", score)
+			}
+		})
+	}
+}
+
+func TestNewUserWarmup(t *testing.T) {
+	db := database.NewInMemoryDatabase()
+	scorer := NewVandalismScorer(DefaultVandalismRules(), db)
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	// Score each edit after the database records it, as the consumer does
+	edit := func(id string, user string, at time.Duration) []string {
+		event := models.Event{ID: id, Time: start.Add(at), Type: "edit", User: user, Comment: "trim", OldLength: 5000, NewLength: 2000}
+		db.RecordEvent(event)
+		_, reasons := scorer.Score(event)
+		return reasons
+	}
+
+	// Right after startup an established editor's cleanup isn't taken for a new user's
+	if reasons := edit("msg1", "alice", 0); !slices.Equal(reasons, []string{"large_removal"}) {
+		t.Errorf("cold start: got %v, want only large_removal", reasons)
+	}
+	steps := []struct {
+		user string
+		want []string
+	}{
+		{user: "newbie", want: []string{"large_removal", "new_user"}},
+		{user: "newbie", want: []string{"large_removal", "new_user"}},
+		{user: "newbie", want: []string{"large_removal", "new_user"}},
+		// Three earlier edits make the user established
+		{user: "newbie", want: []string{"large_removal"}},
+	}
+	for i, step := range steps {
+		if reasons := edit(fmt.Sprintf("msg%d", i+2), step.user, 25*time.Hour); !slices.Equal(reasons, step.want) {
+			t.Errorf("edit %d: got %v, want %v", i+1, reasons, step.want)
+		}
+	}
+}
+
+func TestSuspicious(t *testing.T) {
+	scorer := NewVandalismScorer(DefaultVandalismRules(), nil)
+	events := []models.Event{
+		{ID: "blanked", Type: "edit", User: "alice", OldLength: 5000},
+		{ID: "fine", Type: "edit", User: "alice", Comment: "typo"},
+		{ID: "anonymous blanking", Type: "edit", User: "192.0.2.1", OldLength: 5000},
+		{ID: "log", Type: "log", User: "192.0.2.1", OldLength: 5000},
+	}
+	for _, event := range events {
+		scorer.HandleEvent(event)
+	}
+
+	got := scorer.Suspicious(0, 0)
+	if len(got) != 2 || got[0].Event.ID != "anonymous blanking" || got[1].Event.ID != "blanked" {
+		t.Fatalf("suspicious: got %+v, want newest first", got)
+	}
+	if got[0].Score != 65 {
+		t.Errorf("score: got %d, want 65", got[0].Score)
+	}
+	if filtered := scorer.Suspicious(60, 0); len(filtered) != 1 {
+		t.Errorf("min score: got %d edits, want 1", len(filtered))
+	}
+	if limited := scorer.Suspicious(0, 1); len(limited) != 1 || limited[0].Event.ID != "anonymous blanking" {
+		t.Errorf("limit: got %+v", limited)
+	}
+}
+
+func TestSuspiciousFeedBounded(t *testing.T) {
+	scorer := NewVandalismScorer(DefaultVandalismRules(), nil)
+	for i := range suspiciousFeedSize + 10 {
+		scorer.HandleEvent(models.Event{Type: "edit", User: "alice", OldLength: 5000, OldRevision: i})
+	}
+	got := scorer.Suspicious(0, 0)
+	if len(got) != suspiciousFeedSize {
+		t.Fatalf("feed: got %d, want %d", len(got), suspiciousFeedSize)
+	}
+	if got[0].Event.OldRevision != suspiciousFeedSize+9 || got[len(got)-1].Event.OldRevision != 10 {
+		t.Errorf("feed order: newest %d oldest %d", got[0].Event.OldRevision, got[len(got)-1].Event.OldRevision)
+	}
+}
+
+func TestLoadVandalismRules(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(filename, []byte(`{"anonymous": 50, "threshold": 45}`), 0o600); err != nil {
+		t.Fatalf("Error writing rules: %v", err)
+	}
+	rules, err := LoadVandalismRules(filename)
+	if err != nil {
+		t.Fatalf("LoadVandalismRules() error = %v", err)
+	}
+	if rules.Anonymous != 50 || rules.Threshold != 45 || rules.Blanking != DefaultVandalismRules().Blanking {
+		t.Errorf("rules: got %+v", rules)
+	}
+	if _, err := LoadVandalismRules(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	"wikistats/pkg/export"
+)
+
+// Stream a dataset as CSV or Parquet, such as /export/events?format=parquet&wiki=enwiki&since=24h.
+// Events take the same filters as /events, but every matching event is written rather than a page
+func (s *Service) Export(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	format, err := export.ParseFormat(params.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dataset := r.PathValue("dataset")
+	var write func(w io.Writer) (int, error)
+	switch {
+	case dataset == export.Events && s.events != nil:
+		query, err := ParseEventQuery(params, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		write = func(w io.Writer) (int, error) {
+			return export.WriteEvents(w, format, export.QueryAll(s.events, query))
+		}
+	case dataset == export.Wikis && s.wikis != nil:
+		write = func(w io.Writer) (int, error) {
+			return export.WriteWikis(w, format, s.wikis.GetWikiCounts())
+		}
+	case dataset == export.Namespaces && s.breakdown != nil:
+		write = func(w io.Writer) (int, error) {
+			return export.WriteNamespaces(w, format, s.breakdown.GetBreakdownStats())
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, dataset, format))
+	// Large exports take longer than the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Error lifting the write deadline for an export: %v", err)
+	}
+	// The status has been sent by the time anything fails, so errors can only be logged
+	if _, err := write(w); err != nil {
+		log.Printf("Error exporting %s: %v", dataset, err)
+	}
+}
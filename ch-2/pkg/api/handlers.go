@@ -1,19 +1,120 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wikistats/pkg/analysis"
+	"wikistats/pkg/consumer"
 	"wikistats/pkg/database"
+	"wikistats/pkg/firehose"
+	"wikistats/pkg/models"
+	"wikistats/pkg/notify"
 )
 
 type Service struct {
-	db database.Executer
+	db        database.Executer
+	firehose  *firehose.Hub
+	events    database.EventStore
+	users     database.UserProfiler
+	pages     database.PageTracker
+	trending  database.TrendDetector
+	editors   database.EditorCounter
+	volume    database.VolumeCounter
+	breakdown database.BreakdownCounter
+	crossWiki database.CrossWikiTracker
+	wikis     database.WikiCounter
+	memory    database.MemoryReporter
+	editWars  *analysis.EditWarDetector
+	reverts   *analysis.RevertTracker
+	scorer    *analysis.VandalismScorer
+	sessions  *analysis.SessionTracker
+	anomalies *analysis.AnomalyDetector
+	rules     *analysis.RuleEngine
+	dedupe    *consumer.Deduplicator
+	notifier  *notify.WebhookNotifier
 }
 
 func NewService(db database.Executer) *Service {
-	return &Service{
+	s := &Service{
 		db: db,
 	}
+	// Optional endpoints are enabled when the database supports them
+	s.users, _ = db.(database.UserProfiler)
+	s.pages, _ = db.(database.PageTracker)
+	s.trending, _ = db.(database.TrendDetector)
+	s.editors, _ = db.(database.EditorCounter)
+	s.volume, _ = db.(database.VolumeCounter)
+	s.breakdown, _ = db.(database.BreakdownCounter)
+	s.crossWiki, _ = db.(database.CrossWikiTracker)
+	s.wikis, _ = db.(database.WikiCounter)
+	s.memory, _ = db.(database.MemoryReporter)
+	return s
+}
+
+// Enable the /firehose WebSocket endpoint backed by the given hub
+func (s *Service) WithFirehose(hub *firehose.Hub) *Service {
+	s.firehose = hub
+	return s
+}
+
+// Enable the /events query endpoint backed by the given store
+func (s *Service) WithEventStore(store database.EventStore) *Service {
+	s.events = store
+	return s
+}
+
+// Enable the /alerts/edit-wars endpoint backed by the given detector
+func (s *Service) WithEditWars(detector *analysis.EditWarDetector) *Service {
+	s.editWars = detector
+	return s
+}
+
+// Enable the /stats/reverts endpoint backed by the given tracker
+func (s *Service) WithReverts(tracker *analysis.RevertTracker) *Service {
+	s.reverts = tracker
+	return s
+}
+
+// Enable the /stats/suspicious endpoint backed by the given scorer
+func (s *Service) WithVandalismScorer(scorer *analysis.VandalismScorer) *Service {
+	s.scorer = scorer
+	return s
+}
+
+// Enable the /stats/sessions endpoint backed by the given tracker
+func (s *Service) WithSessions(tracker *analysis.SessionTracker) *Service {
+	s.sessions = tracker
+	return s
+}
+
+// Enable the /alerts endpoint backed by the given detector
+func (s *Service) WithAnomalies(detector *analysis.AnomalyDetector) *Service {
+	s.anomalies = detector
+	return s
+}
+
+// Enable the /alerts/rules endpoint backed by the given engine
+func (s *Service) WithRules(engine *analysis.RuleEngine) *Service {
+	s.rules = engine
+	return s
+}
+
+// Enable the /stats/dedupe endpoint backed by the given deduplicator
+func (s *Service) WithDeduplicator(d *consumer.Deduplicator) *Service {
+	s.dedupe = d
+	return s
+}
+
+// Enable the /alerts/webhooks endpoint backed by the given notifier
+func (s *Service) WithNotifier(notifier *notify.WebhookNotifier) *Service {
+	s.notifier = notifier
+	return s
 }
 
 func (s *Service) Healthcheck(w http.ResponseWriter, r *http.Request) {
@@ -23,5 +124,170 @@ func (s *Service) Healthcheck(w http.ResponseWriter, r *http.Request) {
 func (s *Service) Stats(w http.ResponseWriter, r *http.Request) {
 	messages, users, bots, servers := s.db.GetStats()
 	stats := fmt.Sprintf("%d messages\n%d users\n%d bots\n%d servers", messages, users, bots, servers)
+	if s.editors != nil {
+		editors := s.editors.GetEditorStats(0).Editors
+		stats += fmt.Sprintf("\n%d registered editors\n%d anonymous editors\n%d temporary accounts",
+			editors[models.Registered], editors[models.Anonymous], editors[models.Temporary])
+	}
+	if s.memory != nil {
+		usage := s.memory.GetMemoryUsage()
+		stats += fmt.Sprintf("\n%d message IDs kept for deduplication\n%.1f MB heap", usage.MessageIDs, float64(usage.HeapBytes)/(1<<20))
+	}
 	w.Write([]byte(stats))
 }
+
+func (s *Service) FirehoseStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.firehose.Stats())
+}
+
+func (s *Service) Events(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseEventQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := s.events.QueryEvents(query)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying events: %v", err)
+		http.Error(w, "error querying events", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, page)
+}
+
+func (s *Service) UserProfile(w http.ResponseWriter, r *http.Request) {
+	profile, ok := s.users.GetUserProfile(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, profile)
+}
+
+func (s *Service) PageActivity(w http.ResponseWriter, r *http.Request) {
+	// Titles in URLs conventionally use underscores where the stream uses spaces
+	title := strings.ReplaceAll(r.PathValue("title"), "_", " ")
+	activity, ok := s.pages.GetPageActivity(r.PathValue("wiki"), title)
+	if !ok {
+		http.Error(w, "page not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, activity)
+}
+
+func (s *Service) Trending(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r.URL.Query(), 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.trending.GetTrending(limit))
+}
+
+func (s *Service) CrossWiki(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	limit, err := parseLimit(params, 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minWikis := 0
+	if value := params.Get("min_wikis"); value != "" {
+		if minWikis, err = strconv.Atoi(value); err != nil {
+			http.Error(w, fmt.Sprintf("parsing min_wikis %q: %v", value, err), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, s.crossWiki.GetCrossWikiUsers(minWikis, limit))
+}
+
+func (s *Service) Anomalies(w http.ResponseWriter, r *http.Request) {
+	activeOnly := false
+	if value := r.URL.Query().Get("active"); value != "" {
+		var err error
+		if activeOnly, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, fmt.Sprintf("parsing active %q: %v", value, err), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, s.anomalies.Anomalies(activeOnly))
+}
+
+func (s *Service) Rules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.rules.Rules())
+}
+
+func (s *Service) EditWars(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.editWars.ActiveWars())
+}
+
+func (s *Service) Reverts(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r.URL.Query(), 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.reverts.Summary(limit))
+}
+
+func (s *Service) Sessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.sessions.Summary())
+}
+
+func (s *Service) Dedupe(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.dedupe.Stats())
+}
+
+func (s *Service) Webhooks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.notifier.Stats())
+}
+
+func (s *Service) Suspicious(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	limit, err := parseLimit(params, 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minScore := 0
+	if value := params.Get("min_score"); value != "" {
+		if minScore, err = strconv.Atoi(value); err != nil {
+			http.Error(w, fmt.Sprintf("parsing min_score %q: %v", value, err), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, s.scorer.Suspicious(minScore, limit))
+}
+
+func (s *Service) Editors(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r.URL.Query(), 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.editors.GetEditorStats(limit))
+}
+
+func (s *Service) Volume(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r.URL.Query(), 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.volume.GetVolumeStats(limit))
+}
+
+func (s *Service) Breakdown(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.breakdown.GetBreakdownStats())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Serve the stats in the Prometheus text exposition format
+func (s *Service) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	messages, users, bots, servers := s.db.GetStats()
+	writeMetric(w, "wikistats_messages_total", "counter", "Distinct messages consumed", float64(messages))
+	writeMetric(w, "wikistats_users", "gauge", "Distinct human users seen", float64(users))
+	writeMetric(w, "wikistats_bots", "gauge", "Distinct bots seen", float64(bots))
+	writeMetric(w, "wikistats_servers", "gauge", "Distinct servers seen", float64(servers))
+	if s.memory != nil {
+		usage := s.memory.GetMemoryUsage()
+		writeMetric(w, "wikistats_heap_bytes", "gauge", "Bytes allocated on the heap", float64(usage.HeapBytes))
+		writeMetric(w, "wikistats_message_ids", "gauge", "Message IDs kept for deduplication", float64(usage.MessageIDs))
+		writeMetric(w, "wikistats_profiles", "gauge", "User profiles held in memory", float64(usage.Profiles))
+		writeMetric(w, "wikistats_pages", "gauge", "Pages with activity held in memory", float64(usage.Pages))
+	}
+	if s.dedupe != nil {
+		stats := s.dedupe.Stats()
+		writeHeader(w, "wikistats_duplicates_total", "counter", "Replayed messages skipped, by whether they were recent or only in the bloom filters")
+		writeSample(w, "wikistats_duplicates_total", float64(stats.ExactDuplicates), "match", "exact")
+		writeSample(w, "wikistats_duplicates_total", float64(stats.ProbableDuplicates), "match", "probable")
+		writeMetric(w, "wikistats_dedupe_filter_fill_ratio", "gauge", "How full the newest bloom filter is", stats.FillRatio)
+	}
+	if s.notifier != nil {
+		stats := s.notifier.Stats()
+		writeHeader(w, "wikistats_webhook_alerts_total", "counter", "Alerts by what became of them")
+		writeSample(w, "wikistats_webhook_alerts_total", float64(stats.Delivered), "outcome", "delivered")
+		writeSample(w, "wikistats_webhook_alerts_total", float64(stats.Failed), "outcome", "failed")
+		writeSample(w, "wikistats_webhook_alerts_total", float64(stats.Dropped), "outcome", "dropped")
+		writeSample(w, "wikistats_webhook_alerts_total", float64(stats.RateLimited), "outcome", "rate_limited")
+		writeMetric(w, "wikistats_webhook_retries_total", "counter", "Delivery attempts after the first", float64(stats.Retried))
+		writeMetric(w, "wikistats_webhook_dead_letters_total", "counter", "Failed deliveries written to the dead-letter file", float64(stats.DeadLettered))
+	}
+
+	if s.breakdown != nil {
+		stats := s.breakdown.GetBreakdownStats()
+		writeHeader(w, "wikistats_events_by_type_total", "counter", "Events by change type")
+		types := slices.Sorted(maps.Keys(stats.Types))
+		for _, eventType := range types {
+			writeSample(w, "wikistats_events_by_type_total", float64(stats.Types[eventType]), "type", eventType)
+		}
+		writeHeader(w, "wikistats_events_by_namespace_total", "counter", "Events by namespace")
+		for _, namespace := range stats.Namespaces {
+			writeSample(w, "wikistats_events_by_namespace_total", float64(namespace.Events),
+				"namespace", strconv.Itoa(namespace.ID), "name", namespace.Name)
+		}
+		writeHeader(w, "wikistats_minor_edits_by_namespace_total", "counter", "Edits marked minor by namespace")
+		for _, namespace := range stats.Namespaces {
+			writeSample(w, "wikistats_minor_edits_by_namespace_total", float64(namespace.MinorEdits),
+				"namespace", strconv.Itoa(namespace.ID), "name", namespace.Name)
+		}
+		writeMetric(w, "wikistats_edits_total", "counter", "Edits to existing pages", float64(stats.Edits))
+		writeMetric(w, "wikistats_minor_edits_total", "counter", "Edits to existing pages marked minor", float64(stats.MinorEdits))
+	}
+}
+
+func writeMetric(w io.Writer, name string, kind string, help string, value float64) {
+	writeHeader(w, name, kind, help)
+	writeSample(w, name, value)
+}
+
+func writeHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Write a sample with labels given as alternating names and values
+func writeSample(w io.Writer, name string, value float64, labels ...string) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	if len(pairs) > 0 {
+		name += "{" + strings.Join(pairs, ",") + "}"
+	}
+	fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
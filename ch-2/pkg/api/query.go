@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+	"wikistats/pkg/database"
+)
+
+// Build an event query from parameters of the form
+// ?since=1h&until=2025-02-02T02:22:22Z&wiki=enwiki&user=alice&title=Go&type=edit&namespace=0&bot=false&sort=desc&limit=50&cursor=...
+// The wiki, user and type parameters may be repeated to match any of several values
+func ParseEventQuery(params url.Values, now time.Time) (database.EventQuery, error) {
+	query := database.EventQuery{
+		Wikis:  params["wiki"],
+		Users:  params["user"],
+		Title:  params.Get("title"),
+		Types:  params["type"],
+		Cursor: params.Get("cursor"),
+	}
+	var err error
+	if query.Since, err = parseTime(params.Get("since"), now); err != nil {
+		return database.EventQuery{}, fmt.Errorf("parsing since: %w", err)
+	}
+	if query.Until, err = parseTime(params.Get("until"), now); err != nil {
+		return database.EventQuery{}, fmt.Errorf("parsing until: %w", err)
+	}
+	if value := params.Get("namespace"); value != "" {
+		namespace, err := strconv.Atoi(value)
+		if err != nil {
+			return database.EventQuery{}, fmt.Errorf("parsing namespace %q: %w", value, err)
+		}
+		query.Namespace = &namespace
+	}
+	if value := params.Get("bot"); value != "" {
+		bot, err := strconv.ParseBool(value)
+		if err != nil {
+			return database.EventQuery{}, fmt.Errorf("parsing bot %q: %w", value, err)
+		}
+		query.Bot = &bot
+	}
+	switch sort := params.Get("sort"); sort {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return database.EventQuery{}, fmt.Errorf("unknown sort order %q, expected asc or desc", sort)
+	}
+	if query.Limit, err = parseLimit(params, 0); err != nil {
+		return database.EventQuery{}, err
+	}
+	return query, nil
+}
+
+// Parse the limit parameter, using the fallback when it is unset
+func parseLimit(params url.Values, fallback int) (int, error) {
+	value := params.Get("limit")
+	if value == "" {
+		return fallback, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("parsing limit %q: %w", value, err)
+	}
+	return limit, nil
+}
+
+// Parse an RFC 3339 timestamp, or a duration such as 1h meaning that long before now
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration", value)
+	}
+	return t, nil
+}
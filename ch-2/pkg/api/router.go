@@ -6,5 +6,60 @@ func NewRouter(s *Service) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthcheck", s.Healthcheck)
 	mux.HandleFunc("/stats", s.Stats)
+	mux.HandleFunc("/metrics", s.Metrics)
+	mux.HandleFunc("/export/{dataset}", s.Export)
+	if s.firehose != nil {
+		mux.Handle("/firehose", s.firehose)
+		mux.HandleFunc("/firehose/stats", s.FirehoseStats)
+	}
+	if s.events != nil {
+		mux.HandleFunc("/events", s.Events)
+	}
+	if s.users != nil {
+		mux.HandleFunc("/users/{name}", s.UserProfile)
+	}
+	if s.pages != nil {
+		// Titles of subpages contain slashes, so the title matches the rest of the path
+		mux.HandleFunc("/pages/{wiki}/{title...}", s.PageActivity)
+	}
+	if s.trending != nil {
+		mux.HandleFunc("/stats/trending", s.Trending)
+	}
+	if s.editors != nil {
+		mux.HandleFunc("/stats/editors", s.Editors)
+	}
+	if s.volume != nil {
+		mux.HandleFunc("/stats/volume", s.Volume)
+	}
+	if s.breakdown != nil {
+		mux.HandleFunc("/stats/breakdown", s.Breakdown)
+	}
+	if s.crossWiki != nil {
+		mux.HandleFunc("/stats/cross-wiki", s.CrossWiki)
+	}
+	if s.anomalies != nil {
+		mux.HandleFunc("/alerts", s.Anomalies)
+	}
+	if s.rules != nil {
+		mux.HandleFunc("/alerts/rules", s.Rules)
+	}
+	if s.notifier != nil {
+		mux.HandleFunc("/alerts/webhooks", s.Webhooks)
+	}
+	if s.editWars != nil {
+		mux.HandleFunc("/alerts/edit-wars", s.EditWars)
+	}
+	if s.reverts != nil {
+		mux.HandleFunc("/stats/reverts", s.Reverts)
+	}
+	if s.scorer != nil {
+		mux.HandleFunc("/stats/suspicious", s.Suspicious)
+	}
+	if s.sessions != nil {
+		mux.HandleFunc("/stats/sessions", s.Sessions)
+	}
+	if s.dedupe != nil {
+		mux.HandleFunc("/stats/dedupe", s.Dedupe)
+	}
 	return mux
 }
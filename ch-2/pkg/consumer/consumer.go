@@ -3,9 +3,20 @@ package consumer
 import (
 	"io"
 	"wikistats/pkg/database"
+	"wikistats/pkg/models"
 )
 
 type Consumer interface {
 	Connect() (io.Reader, error)
 	Consume(io.Reader, database.Executer) error
 }
+
+// Handler receives every event read from the stream after it is stored
+type Handler interface {
+	HandleEvent(event models.Event)
+}
+
+// Enricher adds derived fields to events before they are stored
+type Enricher interface {
+	Enrich(event *models.Event)
+}
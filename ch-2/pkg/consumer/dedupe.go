@@ -0,0 +1,181 @@
+package consumer
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+const (
+	DefaultDedupeRecent            = 100000
+	DefaultDedupeFilterCapacity    = 1000000
+	DefaultDedupeFalsePositiveRate = 1e-6
+)
+
+// Deduplicator recognizes messages replayed after reconnecting or restarting, in bounded memory.
+// The most recent IDs are kept exactly, and older ones in a pair of bloom filters that rotate once the
+// newer fills, so IDs are remembered for between one and two filters' worth of messages. An ID only
+// found in the filters is taken as a duplicate, at the configured false positive rate
+type Deduplicator struct {
+	lock sync.Mutex
+	// Recent IDs, with the ring giving the order to forget them in
+	recent    map[string]struct{}
+	ring      []string
+	next      int
+	current   *bloomFilter
+	previous  *bloomFilter
+	capacity  int
+	rate      float64
+	checked   int
+	exact     int
+	probable  int
+	rotations int
+	missing   int
+}
+
+type DedupeStats struct {
+	Checked int `json:"checked"`
+	// Duplicates found in the recent IDs, and in the filters only
+	ExactDuplicates    int `json:"exact_duplicates"`
+	ProbableDuplicates int `json:"probable_duplicates"`
+	Rotations          int `json:"rotations"`
+	RecentIDs          int `json:"recent_ids"`
+	// Messages without an ID, which can't be checked and are always passed on
+	MissingIDs  int     `json:"missing_ids"`
+	FilterBytes int     `json:"filter_bytes"`
+	FillRatio   float64 `json:"fill_ratio"`
+}
+
+func NewDeduplicator(recent int, filterCapacity int, falsePositiveRate float64) *Deduplicator {
+	if recent <= 0 {
+		recent = DefaultDedupeRecent
+	}
+	if filterCapacity <= 0 {
+		filterCapacity = DefaultDedupeFilterCapacity
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = DefaultDedupeFalsePositiveRate
+	}
+	return &Deduplicator{
+		recent:   make(map[string]struct{}, recent),
+		ring:     make([]string, recent),
+		current:  newBloomFilter(filterCapacity, falsePositiveRate),
+		previous: newBloomFilter(filterCapacity, falsePositiveRate),
+		capacity: filterCapacity,
+		rate:     falsePositiveRate,
+	}
+}
+
+// Report whether the message was already seen, remembering it if not
+func (d *Deduplicator) Seen(id string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.checked++
+	if id == "" {
+		d.missing++
+		return false
+	}
+	if _, ok := d.recent[id]; ok {
+		d.exact++
+		return true
+	}
+	if d.current.contains(id) || d.previous.contains(id) {
+		d.probable++
+		return true
+	}
+	d.remember(id)
+	return false
+}
+
+// Remember messages handled before the deduplicator was running, such as those restored from a
+// snapshot or replayed from the WAL on startup, so the stream replaying them is skipped
+func (d *Deduplicator) Remember(id string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if id == "" {
+		return
+	}
+	if _, ok := d.recent[id]; ok {
+		return
+	}
+	d.remember(id)
+}
+
+// Caller must hold the lock
+func (d *Deduplicator) remember(id string) {
+	if old := d.ring[d.next]; old != "" {
+		delete(d.recent, old)
+	}
+	d.ring[d.next] = id
+	d.next = (d.next + 1) % len(d.ring)
+	d.recent[id] = struct{}{}
+	if d.current.count >= d.capacity {
+		d.previous = d.current
+		d.current = newBloomFilter(d.capacity, d.rate)
+		d.rotations++
+	}
+	d.current.add(id)
+}
+
+func (d *Deduplicator) Stats() DedupeStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return DedupeStats{
+		Checked:            d.checked,
+		ExactDuplicates:    d.exact,
+		ProbableDuplicates: d.probable,
+		Rotations:          d.rotations,
+		RecentIDs:          len(d.recent),
+		MissingIDs:         d.missing,
+		FilterBytes:        8 * (len(d.current.bits) + len(d.previous.bits)),
+		FillRatio:          float64(d.current.count) / float64(d.capacity),
+	}
+}
+
+type bloomFilter struct {
+	bits   []uint64
+	hashes int
+	count  int
+}
+
+// Size the filter for the number of items at the false positive rate
+func newBloomFilter(capacity int, falsePositiveRate float64) *bloomFilter {
+	bits := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Round(bits / float64(capacity) * math.Ln2))
+	return &bloomFilter{
+		bits:   make([]uint64, int(bits)/64+1),
+		hashes: max(hashes, 1),
+	}
+}
+
+// Bit positions for the ID, derived from two halves of one hash
+func (f *bloomFilter) positions(id string, visit func(bit uint64) bool) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+	sum := hash.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	size := uint64(len(f.bits) * 64)
+	for i := range uint64(f.hashes) {
+		if !visit((h1 + i*h2) % size) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(id string) {
+	f.positions(id, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+	f.count++
+}
+
+func (f *bloomFilter) contains(id string) bool {
+	return f.positions(id, func(bit uint64) bool {
+		return f.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
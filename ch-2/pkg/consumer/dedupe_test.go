@@ -0,0 +1,125 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"wikistats/pkg/database"
+	"wikistats/pkg/utils"
+)
+
+func TestDeduplicator(t *testing.T) {
+	d := NewDeduplicator(2, 3, 0.001)
+	steps := []struct {
+		id   string
+		want bool
+	}{
+		{id: "msg1", want: false},
+		{id: "msg2", want: false},
+		{id: "msg1", want: true},
+		{id: "msg3", want: false},
+		// msg1 has left the recent IDs but is still in the filter
+		{id: "msg1", want: true},
+		{id: "", want: false},
+		{id: "", want: false},
+		// The filter rotates, keeping msg1 in the previous filter
+		{id: "msg4", want: false},
+		{id: "msg5", want: false},
+		{id: "msg6", want: false},
+		{id: "msg1", want: true},
+		// A second rotation forgets it
+		{id: "msg7", want: false},
+		{id: "msg1", want: false},
+	}
+	for i, step := range steps {
+		if got := d.Seen(step.id); got != step.want {
+			t.Errorf("step %d %q: got %v, want %v", i, step.id, got, step.want)
+		}
+	}
+
+	stats := d.Stats()
+	want := DedupeStats{Checked: 13, ExactDuplicates: 1, ProbableDuplicates: 2, Rotations: 2, RecentIDs: 2, MissingIDs: 2}
+	if stats.Checked != want.Checked || stats.ExactDuplicates != want.ExactDuplicates || stats.ProbableDuplicates != want.ProbableDuplicates ||
+		stats.Rotations != want.Rotations || stats.RecentIDs != want.RecentIDs || stats.MissingIDs != want.MissingIDs {
+		t.Errorf("stats: got %+v, want %+v", stats, want)
+	}
+}
+
+func TestDeduplicatorRemember(t *testing.T) {
+	d := NewDeduplicator(2, 3, 0.001)
+	for _, id := range []string{"msg1", "msg2", "msg1", ""} {
+		d.Remember(id)
+	}
+	if d.Seen("msg3") {
+		t.Error("msg3 seen before being checked")
+	}
+	for _, id := range []string{"msg1", "msg2", "msg3"} {
+		if !d.Seen(id) {
+			t.Errorf("%s not seen", id)
+		}
+	}
+	// Remembered IDs aren't counted as checked
+	if stats := d.Stats(); stats.Checked != 4 || stats.RecentIDs != 2 || stats.MissingIDs != 0 {
+		t.Errorf("stats: got %+v", stats)
+	}
+}
+
+func TestDeduplicatorFalsePositives(t *testing.T) {
+	const messages = 10000
+	d := NewDeduplicator(100, messages, 0.01)
+	falsePositives := 0
+	for i := range messages {
+		if d.Seen(fmt.Sprintf("seen-%d", i)) {
+			falsePositives++
+		}
+	}
+	for i := range messages {
+		if d.Seen(fmt.Sprintf("new-%d", i)) {
+			falsePositives++
+		}
+	}
+	// New messages are checked against an increasingly full filter, so expect at most the rate for a full one
+	// and allow twice that for chance
+	if falsePositives > 2*2*messages/100 {
+		t.Errorf("false positives: got %d of %d, want at most 1%%", falsePositives, 2*messages)
+	}
+}
+
+func TestConsumeDeduplicates(t *testing.T) {
+	if err := utils.LoadEnv(envFile); err != nil {
+		t.Errorf("Could not load env file: %v", err)
+	}
+	consumer, err := NewWikimediaConsumer("test-url")
+	if err != nil {
+		t.Fatalf("Error initializing consumer: %v", err)
+	}
+	handler := &recordingHandler{}
+	consumer.AddHandler(handler)
+	dedupe := NewDeduplicator(0, 0, 0)
+	consumer.SetDeduplicator(dedupe)
+	// A reconnection replaying the first two messages
+	input := `
+data: {"meta": { "id": "msg1", "dt": "2025-02-02T02:22:22Z" }, "user": "alice"}
+data: {"meta": { "id": "msg2", "dt": "2025-02-02T02:22:23Z" }, "user": "bob"}
+data: {"meta": { "id": "msg1", "dt": "2025-02-02T02:22:22Z" }, "user": "alice"}
+data: {"meta": { "id": "msg2", "dt": "2025-02-02T02:22:23Z" }, "user": "bob"}
+data: {"meta": { "id": "msg3", "dt": "2025-02-02T02:22:24Z" }, "user": "corey"}
+`
+	db := database.NewInMemoryDatabase()
+	if err := consumer.Consume(context.Background(), strings.NewReader(input), db); err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if len(handler.events) != 3 {
+		t.Errorf("events: got %d, want 3", len(handler.events))
+	}
+	if messages, _, _, _ := db.GetStats(); messages != 3 {
+		t.Errorf("messages: got %d, want 3", messages)
+	}
+	if stats := dedupe.Stats(); stats.ExactDuplicates != 2 {
+		t.Errorf("exact duplicates: got %d, want 2", stats.ExactDuplicates)
+	}
+	if checkpoint := consumer.Checkpoint(); checkpoint != "2025-02-02T02:22:24Z" {
+		t.Errorf("checkpoint: got %q", checkpoint)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"wikistats/pkg/database"
 	"wikistats/pkg/models"
@@ -25,6 +26,12 @@ type WikimediaConsumer struct {
 	url               string
 	client            *http.Client
 	reconnectionDelay time.Duration
+	enrichers         []Enricher
+	handlers          []Handler
+	dedupe            *Deduplicator
+	lock              sync.Mutex
+	// Timestamp of the last message fully processed
+	checkpoint string
 }
 
 func NewWikimediaConsumer(streamURL string) (*WikimediaConsumer, error) {
@@ -48,6 +55,43 @@ func NewWikimediaConsumer(streamURL string) (*WikimediaConsumer, error) {
 	}, nil
 }
 
+// Register an enricher to be applied to every event before it is stored
+func (c *WikimediaConsumer) AddEnricher(e Enricher) {
+	c.enrichers = append(c.enrichers, e)
+}
+
+// Register a handler to be passed every consumed event
+func (c *WikimediaConsumer) AddHandler(h Handler) {
+	c.handlers = append(c.handlers, h)
+}
+
+// Skip messages the deduplicator has already seen, before they reach the database or any handler
+func (c *WikimediaConsumer) SetDeduplicator(d *Deduplicator) {
+	c.dedupe = d
+}
+
+// Start the stream from a checkpoint, such as one saved with a snapshot, instead of the present
+func (c *WikimediaConsumer) ResumeFrom(checkpoint string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.checkpoint = checkpoint
+	c.url = withSince(c.url, checkpoint)
+}
+
+// Timestamp of the last message that has been stored and passed to every handler
+func (c *WikimediaConsumer) Checkpoint() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.checkpoint
+}
+
+// Update URL to pull messages since the timestamp
+func withSince(streamURL string, timestamp string) string {
+	return fmt.Sprintf("%s?since=%s", strings.Split(streamURL, "?")[0], url.QueryEscape(timestamp))
+}
+
 func (c *WikimediaConsumer) Connect(ctx context.Context) (io.Reader, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", c.url, nil)
 	if err != nil {
@@ -74,7 +118,6 @@ func (c *WikimediaConsumer) Consume(ctx context.Context, r io.Reader, db databas
 		const maxCapacity = 1024 * 1024
 		buf := make([]byte, maxCapacity)
 		scanner.Buffer(buf, maxCapacity)
-		var lastTimestamp string
 		for scanner.Scan() {
 			line := scanner.Bytes()
 			// Identify JSON data lines
@@ -88,8 +131,20 @@ func (c *WikimediaConsumer) Consume(ctx context.Context, r io.Reader, db databas
 				log.Printf("Error parsing JSON: %v", err)
 				continue
 			}
-			lastTimestamp = msg.Meta.DT
-			db.UpdateDatabase(msg.Meta.ID, msg.User, msg.ServerURL, msg.Bot)
+			if c.dedupe != nil && c.dedupe.Seen(msg.Meta.ID) {
+				continue
+			}
+			event := models.NewEvent(msg)
+			for _, e := range c.enrichers {
+				e.Enrich(&event)
+			}
+			database.Record(db, event)
+			for _, h := range c.handlers {
+				h.HandleEvent(event)
+			}
+			c.lock.Lock()
+			c.checkpoint = msg.Meta.DT
+			c.lock.Unlock()
 		}
 		if err := scanner.Err(); err != nil {
 			// Terminate consumer if service is shutting down
@@ -103,8 +158,8 @@ func (c *WikimediaConsumer) Consume(ctx context.Context, r io.Reader, db databas
 					if rc, ok := r.(io.ReadCloser); ok {
 						rc.Close()
 					}
-					// Update URL to pull messages since the last read timestamp
-					c.url = fmt.Sprintf("%s?since=%s", strings.Split(c.url, "?")[0], url.QueryEscape(lastTimestamp))
+					// Pull messages since the last read timestamp
+					c.url = withSince(c.url, c.Checkpoint())
 					select {
 					case <-time.After(c.reconnectionDelay):
 						// Delay before reconnecting to avoid disconnects getting faster
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 	"wikistats/pkg/database"
+	"wikistats/pkg/models"
 	"wikistats/pkg/utils"
 
 	"golang.org/x/net/http2"
@@ -249,3 +250,42 @@ func TestReconnect(t *testing.T) {
 		t.Errorf("Message not stored from w2")
 	}
 }
+
+type recordingHandler struct {
+	events []models.Event
+}
+
+func (h *recordingHandler) HandleEvent(event models.Event) {
+	h.events = append(h.events, event)
+}
+
+func TestConsumeHandlers(t *testing.T) {
+	if err := utils.LoadEnv(envFile); err != nil {
+		t.Errorf("Could not load env file: %v", err)
+	}
+	consumer, err := NewWikimediaConsumer("test-url")
+	if err != nil {
+		t.Fatalf("Error initializing consumer: %v", err)
+	}
+	handler := &recordingHandler{}
+	consumer.AddHandler(handler)
+	input := `
+data: {"meta": { "id": "msg1", "dt": "2025-02-02T02:22:22Z" }, "wiki": "enwiki", "title": "Go", "user": "alice", "length": {"old": 10, "new": 15}}
+data: THIS_IS_NOT_JSON
+data: {"meta": { "id": "msg2" }, "timestamp": 1738462942, "user": "bob", "bot": true}
+`
+	if err := consumer.Consume(context.Background(), strings.NewReader(input), database.NewInMemoryDatabase()); err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if len(handler.events) != 2 {
+		t.Fatalf("events: got %d, want 2", len(handler.events))
+	}
+	first := handler.events[0]
+	if first.ID != "msg1" || first.Wiki != "enwiki" || first.Title != "Go" || first.OldLength != 10 || first.NewLength != 15 {
+		t.Errorf("First event not normalized correctly: %+v", first)
+	}
+	wantTime := time.Date(2025, 2, 2, 2, 22, 22, 0, time.UTC)
+	if !first.Time.Equal(wantTime) || !handler.events[1].Time.Equal(wantTime) {
+		t.Errorf("Event times: got %v and %v, want %v", first.Time, handler.events[1].Time, wantTime)
+	}
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"encoding/binary"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DefaultBoltBatchSize     = 1000
+	DefaultBoltFlushInterval = time.Second
+)
+
+var (
+	boltMessages = []byte("messages")
+	boltUsers    = []byte("users")
+	boltBots     = []byte("bots")
+	boltServers  = []byte("servers")
+	// Size of each set, kept alongside it so stats don't have to walk the sets
+	boltCounts = []byte("counts")
+)
+
+// BoltDatabase keeps the core stats in an embedded bbolt file, so they survive restarts without
+// an external service. Updates are buffered and written in one transaction per batch, so the
+// stream isn't held back by an fsync for every message
+type BoltDatabase struct {
+	db *bolt.DB
+	// Guards the pending updates
+	lock      sync.Mutex
+	pending   []boltUpdate
+	batchSize int
+	// Serializes flushes so batches are written in order
+	flushLock sync.Mutex
+	stop      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+type boltUpdate struct {
+	id     string
+	user   string
+	server string
+	isBot  bool
+}
+
+func OpenBoltDatabase(filename string) (*BoltDatabase, error) {
+	db, err := bolt.Open(filename, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltMessages, boltUsers, boltBots, boltServers, boltCounts} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	d := &BoltDatabase{db: db, batchSize: DefaultBoltBatchSize, stop: make(chan struct{})}
+	d.stopped.Add(1)
+	go d.flushLoop(DefaultBoltFlushInterval)
+	return d, nil
+}
+
+// Queue the update, writing the queue once it holds a full batch
+func (d *BoltDatabase) UpdateDatabase(id string, user string, server string, isBot bool) {
+	d.lock.Lock()
+	d.pending = append(d.pending, boltUpdate{id: id, user: user, server: server, isBot: isBot})
+	full := len(d.pending) >= d.batchSize
+	d.lock.Unlock()
+
+	if full {
+		if err := d.flush(); err != nil {
+			log.Printf("Error updating bolt database: %v", err)
+		}
+	}
+}
+
+// Write the queued updates in one transaction. Failed batches are put back to be retried
+func (d *BoltDatabase) flush() error {
+	d.flushLock.Lock()
+	defer d.flushLock.Unlock()
+
+	d.lock.Lock()
+	batch := d.pending
+	d.pending = nil
+	d.lock.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		for _, update := range batch {
+			if err := boltAdd(tx, boltMessages, update.id); err != nil {
+				return err
+			}
+			people := boltUsers
+			if update.isBot {
+				people = boltBots
+			}
+			if err := boltAdd(tx, people, update.user); err != nil {
+				return err
+			}
+			if err := boltAdd(tx, boltServers, update.server); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		d.lock.Lock()
+		d.pending = append(batch, d.pending...)
+		d.lock.Unlock()
+	}
+	return err
+}
+
+func (d *BoltDatabase) flushLoop(interval time.Duration) {
+	defer d.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			if err := d.flush(); err != nil {
+				log.Printf("Error updating bolt database: %v", err)
+			}
+		}
+	}
+}
+
+// Read the counts, after writing any queued updates
+func (d *BoltDatabase) GetStats() (messages int, users int, bots int, servers int) {
+	if err := d.flush(); err != nil {
+		log.Printf("Error updating bolt database: %v", err)
+	}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		counts := tx.Bucket(boltCounts)
+		messages = boltCount(counts, boltMessages)
+		users = boltCount(counts, boltUsers)
+		bots = boltCount(counts, boltBots)
+		servers = boltCount(counts, boltServers)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error reading bolt database: %v", err)
+	}
+	return messages, users, bots, servers
+}
+
+// Write the queued updates and close the file
+func (d *BoltDatabase) Close() error {
+	close(d.stop)
+	d.stopped.Wait()
+	err := d.flush()
+	if closeErr := d.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Add the value to the set, counting it if it's new
+func boltAdd(tx *bolt.Tx, set []byte, value string) error {
+	bucket := tx.Bucket(set)
+	// bbolt rejects empty keys, and the stream can send empty names
+	key := append([]byte{'='}, value...)
+	if bucket.Get(key) != nil {
+		return nil
+	}
+	if err := bucket.Put(key, []byte{1}); err != nil {
+		return err
+	}
+	counts := tx.Bucket(boltCounts)
+	return counts.Put(set, binary.BigEndian.AppendUint64(nil, uint64(boltCount(counts, set)+1)))
+}
+
+func boltCount(counts *bolt.Bucket, set []byte) int {
+	value := counts.Get(set)
+	if value == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(value))
+}
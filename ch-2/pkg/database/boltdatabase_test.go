@@ -0,0 +1,55 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltDatabaseReopen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "wikistats.db")
+	db, err := OpenBoltDatabase(filename)
+	if err != nil {
+		t.Fatalf("OpenBoltDatabase() error = %v", err)
+	}
+	db.UpdateDatabase("msg1", "alice", "server1", false)
+	db.UpdateDatabase("msg2", "bob", "server2", true)
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Stats carry on from the file, and messages seen before the restart aren't counted twice
+	db, err = OpenBoltDatabase(filename)
+	if err != nil {
+		t.Fatalf("OpenBoltDatabase() error = %v", err)
+	}
+	defer db.Close()
+	db.UpdateDatabase("msg2", "bob", "server2", true)
+	db.UpdateDatabase("msg3", "corey", "server1", false)
+	assertStats(t, db, wantState{messages: 3, users: 2, bots: 1, servers: 2})
+}
+
+func TestStoredDatabase(t *testing.T) {
+	store, err := OpenStore(BackendBolt, filepath.Join(t.TempDir(), "wikistats.db"))
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	defer store.Close()
+	aggregates := NewInMemoryDatabase()
+	db := NewStoredDatabase(store, aggregates)
+	for _, event := range snapshotEvents() {
+		db.RecordEvent(event)
+	}
+	// The stats come from the store even when memory has lost them
+	restarted := NewStoredDatabase(store, NewInMemoryDatabase())
+	assertStats(t, restarted, wantState{messages: 6, users: 3, bots: 1, servers: 3})
+	if profile, ok := db.GetUserProfile("alice"); !ok || profile.Edits != 3 {
+		t.Errorf("alice: got %+v, want 3 edits", profile)
+	}
+
+	if _, err := OpenStore("floppy", ""); err == nil {
+		t.Error("Expected error for unknown backend")
+	}
+	if _, err := OpenStore(BackendBolt, ""); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"wikistats/pkg/models"
+)
+
+// Events, edits and minor edits in a namespace
+type NamespaceStats struct {
+	ID         int     `json:"id"`
+	Name       string  `json:"name"`
+	Events     int     `json:"events"`
+	Edits      int     `json:"edits"`
+	MinorEdits int     `json:"minor_edits"`
+	MinorRatio float64 `json:"minor_ratio"`
+}
+
+// Events by change type and namespace, and how many edits are marked minor
+type BreakdownStats struct {
+	Types      map[string]int   `json:"types"`
+	Namespaces []NamespaceStats `json:"namespaces"`
+	Edits      int              `json:"edits"`
+	MinorEdits int              `json:"minor_edits"`
+	MinorRatio float64          `json:"minor_ratio"`
+}
+
+type namespaceCounts struct {
+	events     int
+	edits      int
+	minorEdits int
+}
+
+type breakdownCounts struct {
+	types      map[string]int
+	namespaces map[int]*namespaceCounts
+	edits      int
+	minorEdits int
+}
+
+func newBreakdownCounts() *breakdownCounts {
+	return &breakdownCounts{
+		types:      make(map[string]int),
+		namespaces: make(map[int]*namespaceCounts),
+	}
+}
+
+func (b *breakdownCounts) record(event models.Event) {
+	b.types[event.Type]++
+	counts, ok := b.namespaces[event.Namespace]
+	if !ok {
+		counts = &namespaceCounts{}
+		b.namespaces[event.Namespace] = counts
+	}
+	counts.events++
+	// Only edits to existing pages can be marked minor
+	if event.Type != "edit" {
+		return
+	}
+	counts.edits++
+	b.edits++
+	if event.Minor {
+		counts.minorEdits++
+		b.minorEdits++
+	}
+}
+
+func ratio(part int, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+func (d *InMemoryDatabase) GetBreakdownStats() BreakdownStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	stats := BreakdownStats{
+		Types:      maps.Clone(d.breakdown.types),
+		Namespaces: make([]NamespaceStats, 0, len(d.breakdown.namespaces)),
+		Edits:      d.breakdown.edits,
+		MinorEdits: d.breakdown.minorEdits,
+		MinorRatio: ratio(d.breakdown.minorEdits, d.breakdown.edits),
+	}
+	for id, counts := range d.breakdown.namespaces {
+		stats.Namespaces = append(stats.Namespaces, NamespaceStats{
+			ID:         id,
+			Name:       models.NamespaceName(id),
+			Events:     counts.events,
+			Edits:      counts.edits,
+			MinorEdits: counts.minorEdits,
+			MinorRatio: ratio(counts.minorEdits, counts.edits),
+		})
+	}
+	slices.SortFunc(stats.Namespaces, func(a, b NamespaceStats) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return stats
+}
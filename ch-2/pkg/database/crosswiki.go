@@ -0,0 +1,146 @@
+package database
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	DefaultCrossWikiWindow = 24 * time.Hour
+	// Wikis a user must edit within the window to be listed when no minimum is given
+	DefaultCrossWikiMinWikis = 3
+	// Edits are counted in time buckets, so counts are exact to within this fraction of the window
+	crossWikiBuckets = 24
+)
+
+// A user who has edited several wikis within the cross-wiki window
+type CrossWikiUser struct {
+	User string `json:"user"`
+	Bot  bool   `json:"bot"`
+	// Edits to the listed wikis within the window
+	Edits    int                  `json:"edits"`
+	Wikis    map[string]time.Time `json:"wikis"`
+	LastSeen time.Time            `json:"last_seen"`
+}
+
+// Wikis each user has edited within the window and when they last did, forgetting older edits
+type crossWikiActivity struct {
+	window    time.Duration
+	users     map[string]*crossWikiUser
+	lastSweep time.Time
+}
+
+type crossWikiUser struct {
+	bot bool
+	// Edits to each wiki by the start of their time bucket
+	edits map[string]map[int64]int
+	last  map[string]time.Time
+}
+
+func newCrossWikiActivity(window time.Duration) *crossWikiActivity {
+	return &crossWikiActivity{
+		window: window,
+		users:  make(map[string]*crossWikiUser),
+	}
+}
+
+func newCrossWikiUser(bot bool) *crossWikiUser {
+	return &crossWikiUser{bot: bot, edits: make(map[string]map[int64]int), last: make(map[string]time.Time)}
+}
+
+func (c *crossWikiActivity) bucketWidth() time.Duration {
+	return c.window / crossWikiBuckets
+}
+
+func (c *crossWikiActivity) record(event models.Event, now time.Time) {
+	user, ok := c.users[event.User]
+	if !ok {
+		user = newCrossWikiUser(event.Bot)
+		c.users[event.User] = user
+	}
+	user.bot = user.bot || event.Bot
+	user.prune(now.Add(-c.window), c.bucketWidth())
+	user.add(event.Wiki, event.Time, 1, c.bucketWidth())
+	if event.Time.After(user.last[event.Wiki]) {
+		user.last[event.Wiki] = event.Time
+	}
+
+	// Drop users who have gone quiet so memory only holds recent editors
+	if now.Sub(c.lastSweep) >= c.window {
+		c.lastSweep = now
+		for name, user := range c.users {
+			if user.prune(now.Add(-c.window), c.bucketWidth()); len(user.last) == 0 {
+				delete(c.users, name)
+			}
+		}
+	}
+}
+
+// Count edits to the wiki in the bucket holding the time
+func (u *crossWikiUser) add(wiki string, at time.Time, edits int, width time.Duration) {
+	buckets, ok := u.edits[wiki]
+	if !ok {
+		buckets = make(map[int64]int)
+		u.edits[wiki] = buckets
+	}
+	buckets[at.Truncate(width).UnixNano()] += edits
+}
+
+// Forget wikis the user hasn't edited since the cutoff, and buckets of edits ending before it
+func (u *crossWikiUser) prune(cutoff time.Time, width time.Duration) {
+	for wiki, last := range u.last {
+		if last.Before(cutoff) {
+			delete(u.last, wiki)
+			delete(u.edits, wiki)
+			continue
+		}
+		for start := range u.edits[wiki] {
+			if !time.Unix(0, start).Add(width).After(cutoff) {
+				delete(u.edits[wiki], start)
+			}
+		}
+	}
+}
+
+// Users who edited at least minWikis wikis within the window, most wikis first, at most limit when limit is positive
+func (d *InMemoryDatabase) GetCrossWikiUsers(minWikis int, limit int) []CrossWikiUser {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if minWikis <= 0 {
+		minWikis = DefaultCrossWikiMinWikis
+	}
+	cutoff := d.latest.Add(-d.crossWiki.window)
+	users := make([]CrossWikiUser, 0)
+	for name, user := range d.crossWiki.users {
+		if user.prune(cutoff, d.crossWiki.bucketWidth()); len(user.last) < minWikis {
+			continue
+		}
+		crossWikiUser := CrossWikiUser{User: name, Bot: user.bot, Wikis: maps.Clone(user.last)}
+		for wiki, last := range user.last {
+			for _, edits := range user.edits[wiki] {
+				crossWikiUser.Edits += edits
+			}
+			if last.After(crossWikiUser.LastSeen) {
+				crossWikiUser.LastSeen = last
+			}
+		}
+		users = append(users, crossWikiUser)
+	}
+	slices.SortFunc(users, func(a, b CrossWikiUser) int {
+		if order := cmp.Compare(len(b.Wikis), len(a.Wikis)); order != 0 {
+			return order
+		}
+		if order := b.LastSeen.Compare(a.LastSeen); order != 0 {
+			return order
+		}
+		return cmp.Compare(a.User, b.User)
+	})
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+	}
+	return users
+}
@@ -1,6 +1,62 @@
 package database
 
+import "wikistats/pkg/models"
+
 type Executer interface {
 	UpdateDatabase(messageID string, username string, servername string, isBot bool)
 	GetStats() (messages int, users int, bots int, servers int)
 }
+
+// EventRecorder is implemented by databases that aggregate more than the core stats from each event
+type EventRecorder interface {
+	RecordEvent(event models.Event)
+}
+
+// Record the event in the database, as a whole event if it takes them and as the core stats otherwise
+func Record(db Executer, event models.Event) {
+	if recorder, ok := db.(EventRecorder); ok {
+		recorder.RecordEvent(event)
+	} else {
+		db.UpdateDatabase(event.ID, event.User, event.Server, event.Bot)
+	}
+}
+
+// UserProfiler is implemented by databases that keep per-user aggregates
+type UserProfiler interface {
+	GetUserProfile(name string) (UserProfile, bool)
+}
+
+// PageTracker is implemented by databases that keep per-page edit activity
+type PageTracker interface {
+	GetPageActivity(wiki string, title string) (PageActivity, bool)
+}
+
+// TrendDetector is implemented by databases that can rank pages by how much their edit rate is spiking
+type TrendDetector interface {
+	GetTrending(limit int) []TrendingPage
+}
+
+// EditorCounter is implemented by databases that count registered, anonymous and temporary editors separately
+type EditorCounter interface {
+	GetEditorStats(limit int) EditorStats
+}
+
+// VolumeCounter is implemented by databases that track how much content edits add and remove
+type VolumeCounter interface {
+	GetVolumeStats(limit int) VolumeStats
+}
+
+// BreakdownCounter is implemented by databases that count events by change type and namespace
+type BreakdownCounter interface {
+	GetBreakdownStats() BreakdownStats
+}
+
+// CrossWikiTracker is implemented by databases that track which wikis each user has recently edited
+type CrossWikiTracker interface {
+	GetCrossWikiUsers(minWikis int, limit int) []CrossWikiUser
+}
+
+// WikiCounter is implemented by databases that keep running totals of events and edits per wiki
+type WikiCounter interface {
+	GetWikiCounts() map[string]WikiCounts
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"math"
+	"time"
+)
+
+// Exponentially decayed event count that halves every half-life
+type decayedCounter struct {
+	value   float64
+	updated time.Time
+}
+
+// Count an event at time t, which may be earlier than the last update
+func (c *decayedCounter) add(t time.Time, halfLife time.Duration) {
+	if t.Before(c.updated) {
+		c.value += decay(c.updated.Sub(t), halfLife)
+		return
+	}
+	c.value = c.at(t, halfLife) + 1
+	c.updated = t
+}
+
+// Value of the counter decayed forward to time t
+func (c decayedCounter) at(t time.Time, halfLife time.Duration) float64 {
+	if !t.After(c.updated) {
+		return c.value
+	}
+	return c.value * decay(t.Sub(c.updated), halfLife)
+}
+
+// Approximate events per second, since a steady rate r settles at r * halfLife / ln 2
+func (c decayedCounter) rate(t time.Time, halfLife time.Duration) float64 {
+	return c.at(t, halfLife) * math.Ln2 / halfLife.Seconds()
+}
+
+func decay(elapsed time.Duration, halfLife time.Duration) float64 {
+	return math.Exp2(-elapsed.Seconds() / halfLife.Seconds())
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"cmp"
+	"maps"
+	"net/netip"
+	"slices"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	DefaultIPv4Prefix = 24
+	DefaultIPv6Prefix = 64
+)
+
+// Distinct editors and edits by type of account, and anonymous edits by address range and country
+type EditorStats struct {
+	Editors   map[models.EditorType]int `json:"editors"`
+	Edits     map[models.EditorType]int `json:"edits"`
+	Prefixes  []PrefixStats             `json:"anonymous_prefixes"`
+	Countries map[string]int            `json:"anonymous_countries"`
+}
+
+// Anonymous activity from a single CIDR range
+type PrefixStats struct {
+	Prefix    string `json:"prefix"`
+	Edits     int    `json:"edits"`
+	Addresses int    `json:"addresses"`
+}
+
+type prefixCounts struct {
+	edits int
+	// When each address was last seen
+	addresses map[netip.Addr]time.Time
+}
+
+// Caller must hold the lock
+func (d *InMemoryDatabase) recordEditor(event models.Event, at time.Time) {
+	editorType := models.ClassifyEditor(event.User)
+	if d.editors[editorType] == nil {
+		d.editors[editorType] = make(map[string]time.Time)
+	}
+	touch(d.editors[editorType], event.User, at)
+	d.editorEdits[editorType]++
+	if editorType != models.Anonymous {
+		return
+	}
+	if event.Country != "" {
+		d.countries[event.Country]++
+	}
+	addr, err := netip.ParseAddr(event.User)
+	if err != nil {
+		return
+	}
+	addr = addr.Unmap()
+	bits := d.ipv6Prefix
+	if addr.Is4() {
+		bits = d.ipv4Prefix
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return
+	}
+	counts, ok := d.prefixes[prefix]
+	if !ok {
+		counts = &prefixCounts{addresses: make(map[netip.Addr]time.Time)}
+		d.prefixes[prefix] = counts
+	}
+	counts.edits++
+	if last, ok := counts.addresses[addr]; !ok || at.After(last) {
+		counts.addresses[addr] = at
+	}
+}
+
+// Forget editors and anonymous addresses last seen before the cutoff, and ranges left without any
+// addresses. Caller must hold the lock
+func (d *InMemoryDatabase) expireEditors(cutoff time.Time) {
+	for _, editors := range d.editors {
+		for name, last := range editors {
+			if last.Before(cutoff) {
+				delete(editors, name)
+			}
+		}
+	}
+	for prefix, counts := range d.prefixes {
+		for addr, last := range counts.addresses {
+			if last.Before(cutoff) {
+				delete(counts.addresses, addr)
+			}
+		}
+		if len(counts.addresses) == 0 {
+			delete(d.prefixes, prefix)
+		}
+	}
+}
+
+// Editor counts by type and the anonymous ranges with the most edits, at most limit when limit is positive
+func (d *InMemoryDatabase) GetEditorStats(limit int) EditorStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	stats := EditorStats{
+		Editors:   make(map[models.EditorType]int),
+		Edits:     make(map[models.EditorType]int),
+		Prefixes:  make([]PrefixStats, 0, len(d.prefixes)),
+		Countries: maps.Clone(d.countries),
+	}
+	for _, editorType := range []models.EditorType{models.Registered, models.Anonymous, models.Temporary} {
+		stats.Editors[editorType] = len(d.editors[editorType])
+		stats.Edits[editorType] = d.editorEdits[editorType]
+	}
+	for prefix, counts := range d.prefixes {
+		stats.Prefixes = append(stats.Prefixes, PrefixStats{
+			Prefix:    prefix.String(),
+			Edits:     counts.edits,
+			Addresses: len(counts.addresses),
+		})
+	}
+	slices.SortFunc(stats.Prefixes, func(a, b PrefixStats) int {
+		if order := cmp.Compare(b.Edits, a.Edits); order != 0 {
+			return order
+		}
+		return cmp.Compare(a.Prefix, b.Prefix)
+	})
+	if limit > 0 && len(stats.Prefixes) > limit {
+		stats.Prefixes = stats.Prefixes[:limit]
+	}
+	return stats
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EventStore keeps consumed events so they can be queried after aggregation
+type EventStore interface {
+	StoreEvent(event models.Event)
+	QueryEvents(query EventQuery) (EventPage, error)
+}
+
+// Filters and paging for an event query, unset fields match everything
+type EventQuery struct {
+	Since      time.Time
+	Until      time.Time
+	Wikis      []string
+	Users      []string
+	Title      string
+	Types      []string
+	Namespace  *int
+	Bot        *bool
+	Descending bool
+	Limit      int
+	Cursor     string
+}
+
+// A page of query results and the cursor to fetch the next one, empty when there are no more
+type EventPage struct {
+	Events     []models.Event `json:"events"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+func (q EventQuery) Match(event models.Event) bool {
+	if !q.Since.IsZero() && event.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !event.Time.Before(q.Until) {
+		return false
+	}
+	if len(q.Wikis) > 0 && !slices.Contains(q.Wikis, event.Wiki) {
+		return false
+	}
+	if len(q.Users) > 0 && !slices.Contains(q.Users, event.User) {
+		return false
+	}
+	if q.Title != "" && q.Title != event.Title {
+		return false
+	}
+	if len(q.Types) > 0 && !slices.Contains(q.Types, event.Type) {
+		return false
+	}
+	if q.Namespace != nil && *q.Namespace != event.Namespace {
+		return false
+	}
+	if q.Bot != nil && *q.Bot != event.Bot {
+		return false
+	}
+	return true
+}
+
+// Clamp the requested page size to the allowed range
+func (q EventQuery) PageSize() int {
+	if q.Limit <= 0 {
+		return DefaultQueryLimit
+	}
+	return min(q.Limit, MaxQueryLimit)
+}
+
+// Position of an event in the sort order, events are ordered by time then by insertion sequence
+type cursor struct {
+	time     time.Time
+	sequence uint64
+}
+
+func encodeCursor(c cursor) string {
+	raw := fmt.Sprintf("%s|%d", c.time.Format(time.RFC3339Nano), c.sequence)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	timestamp, sequence, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return cursor{}, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	seq, err := strconv.ParseUint(sequence, 10, 64)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return cursor{time: t, sequence: seq}, nil
+}
+
+// Whether a comes before b in ascending order
+func (a cursor) before(b cursor) bool {
+	if !a.time.Equal(b.time) {
+		return a.time.Before(b.time)
+	}
+	return a.sequence < b.sequence
+}
@@ -1,40 +1,213 @@
 package database
 
-import "sync"
+import (
+	"net/netip"
+	"sync"
+	"time"
+	"wikistats/pkg/models"
+)
 
 type InMemoryDatabase struct {
-	lock     sync.Mutex
-	messages map[string]struct{}
-	users    map[string]struct{}
-	bots     map[string]struct{}
-	servers  map[string]struct{}
+	lock sync.Mutex
+	// Recent message IDs for recognizing duplicates, and the count of every distinct message
+	messages     *messageWindow
+	messageCount int
+	// When each user, bot and server was last seen
+	users        map[string]time.Time
+	bots         map[string]time.Time
+	servers      map[string]time.Time
+	setTTL       time.Duration
+	lastSetSweep time.Time
+	profiles     map[string]*UserProfile
+	pages        map[pageKey]*PageActivity
+	// Pages with enough recent edits to trend, so ranking doesn't visit every page
+	hotPages      map[pageKey]struct{}
+	pageRetention time.Duration
+	lastPageSweep time.Time
+	wikis         map[string]*WikiCounts
+	// Distinct editors and edit counts by account type, and anonymous edits by address range and country
+	editors     map[models.EditorType]map[string]time.Time
+	editorEdits map[models.EditorType]int
+	prefixes    map[netip.Prefix]*prefixCounts
+	countries   map[string]int
+	volume      *volumeCounts
+	breakdown   *breakdownCounts
+	crossWiki   *crossWikiActivity
+	ipv4Prefix  int
+	ipv6Prefix  int
+	// Time of the newest event, used as the current time so aggregates follow the stream
+	latest           time.Time
+	trendingWindow   time.Duration
+	trendingHalfLife time.Duration
 }
 
 func NewInMemoryDatabase() *InMemoryDatabase {
 	return &InMemoryDatabase{
-		messages: make(map[string]struct{}),
-		users:    make(map[string]struct{}),
-		bots:     make(map[string]struct{}),
-		servers:  make(map[string]struct{}),
+		messages: newMessageWindow(DefaultMessageRetention),
+		users:    make(map[string]time.Time),
+		bots:     make(map[string]time.Time),
+		servers:  make(map[string]time.Time),
+		profiles: make(map[string]*UserProfile),
+		pages:    make(map[pageKey]*PageActivity),
+		hotPages: make(map[pageKey]struct{}),
+		wikis:    make(map[string]*WikiCounts),
+
+		editors:     make(map[models.EditorType]map[string]time.Time),
+		editorEdits: make(map[models.EditorType]int),
+		prefixes:    make(map[netip.Prefix]*prefixCounts),
+		countries:   make(map[string]int),
+		volume:      newVolumeCounts(),
+		breakdown:   newBreakdownCounts(),
+		crossWiki:   newCrossWikiActivity(DefaultCrossWikiWindow),
+		ipv4Prefix:  DefaultIPv4Prefix,
+		ipv6Prefix:  DefaultIPv6Prefix,
+
+		trendingWindow:   DefaultTrendingWindow,
+		trendingHalfLife: DefaultTrendingHalfLife,
+	}
+}
+
+// Set the CIDR prefix lengths anonymous edits are grouped by
+func (d *InMemoryDatabase) WithAnonymousPrefixes(ipv4 int, ipv6 int) *InMemoryDatabase {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if ipv4 > 0 && ipv4 <= 32 {
+		d.ipv4Prefix = ipv4
+	}
+	if ipv6 > 0 && ipv6 <= 128 {
+		d.ipv6Prefix = ipv6
 	}
+	return d
+}
+
+// Set how recently users must have edited wikis for them to count towards cross-wiki activity
+func (d *InMemoryDatabase) WithCrossWikiWindow(window time.Duration) *InMemoryDatabase {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if window > 0 {
+		d.crossWiki.window = window
+	}
+	return d
+}
+
+// Set how recent edits must be to count towards trending and how slowly the baseline forgets
+func (d *InMemoryDatabase) WithTrending(window time.Duration, halfLife time.Duration) *InMemoryDatabase {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if window > 0 {
+		d.trendingWindow = window
+	}
+	if halfLife > 0 {
+		d.trendingHalfLife = halfLife
+	}
+	return d
 }
 
 func (d *InMemoryDatabase) UpdateDatabase(id string, user string, server string, isBot bool) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	d.messages[id] = struct{}{}
+	d.update(id, user, server, isBot, time.Now())
+}
+
+// Update the core stats and every aggregate, ignoring aggregates for messages already seen
+func (d *InMemoryDatabase) RecordEvent(event models.Event) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	at := event.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	if seen := d.update(event.ID, event.User, event.Server, event.Bot, at); seen {
+		return
+	}
+	if event.Time.After(d.latest) {
+		d.latest = event.Time
+	}
+	profile, ok := d.profiles[event.User]
+	if !ok {
+		profile = newUserProfile(event.User)
+		d.profiles[event.User] = profile
+	}
+	profile.record(event)
+	wiki, ok := d.wikis[event.Wiki]
+	if !ok {
+		wiki = &WikiCounts{}
+		d.wikis[event.Wiki] = wiki
+	}
+	wiki.record(event)
+	d.recordEditor(event, at)
+	d.breakdown.record(event)
+	if event.IsEdit() {
+		key := pageKey{wiki: event.Wiki, title: event.Title}
+		page, ok := d.pages[key]
+		if !ok {
+			page = newPageActivity(event.Wiki, event.Title)
+			d.pages[key] = page
+		}
+		page.record(event)
+		page.trend.add(event.Time, d.trendingWindow, d.trendingHalfLife)
+		d.markHot(key, page)
+		d.expirePages()
+		d.volume.record(event, d.latest)
+		d.crossWiki.record(event, d.latest)
+	}
+}
+
+// Update the core stats, returning whether the message was already seen. Caller must hold the lock
+func (d *InMemoryDatabase) update(id string, user string, server string, isBot bool, at time.Time) bool {
+	seen := d.messages.contains(id)
+	if !seen {
+		d.messages.add(id, at)
+		d.messageCount++
+	}
 	if isBot {
-		d.bots[user] = struct{}{}
+		touch(d.bots, user, at)
 	} else {
-		d.users[user] = struct{}{}
+		touch(d.users, user, at)
 	}
-	d.servers[server] = struct{}{}
+	touch(d.servers, server, at)
+	d.expireSets()
+	return seen
 }
 
 func (d *InMemoryDatabase) GetStats() (messages int, users int, bots int, servers int) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	return len(d.messages), len(d.users), len(d.bots), len(d.servers)
+	return d.messageCount, len(d.users), len(d.bots), len(d.servers)
+}
+
+func (d *InMemoryDatabase) GetUserProfile(name string) (UserProfile, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	profile, ok := d.profiles[name]
+	if !ok {
+		return UserProfile{}, false
+	}
+	return profile.clone(), true
+}
+
+func (d *InMemoryDatabase) GetPageActivity(wiki string, title string) (PageActivity, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	page, ok := d.pages[pageKey{wiki: wiki, title: title}]
+	if !ok {
+		return PageActivity{}, false
+	}
+	return page.clone(), true
+}
+
+// Pages with the highest trending scores, at most limit when limit is positive
+func (d *InMemoryDatabase) GetTrending(limit int) []TrendingPage {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.trending(limit)
 }
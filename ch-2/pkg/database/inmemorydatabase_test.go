@@ -2,8 +2,15 @@ package database
 
 import (
 	"fmt"
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
+	"time"
+	"wikistats/pkg/models"
 )
 
 type updateArgs struct {
@@ -20,11 +27,40 @@ type wantState struct {
 	servers  int
 }
 
-func assertStats(t *testing.T, db *InMemoryDatabase, want wantState) {
+// Every storage backend the core stats tests run against
+var storageBackends = []struct {
+	name string
+	open func(t *testing.T) Executer
+	// Most updates a test should make, since backends that write to disk take minutes for millions
+	maxUpdates int
+	// Relative error allowed in message counts, for backends that estimate them
+	messageTolerance float64
+}{
+	{name: BackendMemory, open: func(t *testing.T) Executer { return NewInMemoryDatabase() }},
+	{name: BackendBolt, open: func(t *testing.T) Executer {
+		db, err := OpenBoltDatabase(filepath.Join(t.TempDir(), "wikistats.db"))
+		if err != nil {
+			t.Fatalf("OpenBoltDatabase() error = %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}},
+	{name: BackendPostgres, maxUpdates: 100000, open: openTestPostgres},
+	// Message counts are HyperLogLog estimates, within about 1% and exact only for small counts
+	{name: BackendRedis, messageTolerance: 0.02, open: openTestRedis},
+}
+
+func assertStats(t *testing.T, db Executer, want wantState) {
+	t.Helper()
+	assertStatsWithin(t, db, want, 0)
+}
+
+// Assert the stats, allowing the message count to be off by the tolerance relative to the wanted count
+func assertStatsWithin(t *testing.T, db Executer, want wantState, tolerance float64) {
 	t.Helper()
 	gotMessages, gotUsers, gotBots, gotServers := db.GetStats()
-	if gotMessages != want.messages {
-		t.Errorf("messages: got %d, want %d", gotMessages, want.messages)
+	if math.Abs(float64(gotMessages-want.messages)) > tolerance*float64(want.messages) {
+		t.Errorf("messages: got %d, want %d within %v", gotMessages, want.messages, tolerance)
 	}
 	if gotUsers != want.users {
 		t.Errorf("users: got %d, want %d", gotUsers, want.users)
@@ -90,14 +126,16 @@ func TestUpdateDatabase(t *testing.T) {
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			db := NewInMemoryDatabase()
-			for _, op := range tt.updates {
-				db.UpdateDatabase(op.id, op.user, op.server, op.isBot)
-			}
-			assertStats(t, db, tt.want)
-		})
+	for _, backend := range storageBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				db := backend.open(t)
+				for _, op := range tt.updates {
+					db.UpdateDatabase(op.id, op.user, op.server, op.isBot)
+				}
+				assertStatsWithin(t, db, tt.want, backend.messageTolerance)
+			})
+		}
 	}
 }
 
@@ -123,14 +161,16 @@ func TestGetStats(t *testing.T) {
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			db := NewInMemoryDatabase()
-			for _, op := range tt.updates {
-				db.UpdateDatabase(op.id, op.user, op.server, op.isBot)
-			}
-			assertStats(t, db, tt.want)
-		})
+	for _, backend := range storageBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				db := backend.open(t)
+				for _, op := range tt.updates {
+					db.UpdateDatabase(op.id, op.user, op.server, op.isBot)
+				}
+				assertStatsWithin(t, db, tt.want, backend.messageTolerance)
+			})
+		}
 	}
 }
 
@@ -161,24 +201,486 @@ func TestConcurrentExecution(t *testing.T) {
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			db := NewInMemoryDatabase()
-			var wg sync.WaitGroup
-			wg.Add(tt.goroutines)
-			for i := 0; i < tt.goroutines; i++ {
-				go func(routine int) {
-					defer wg.Done()
-					for j := 0; j < tt.opsPerGoroutine; j++ {
-						id := fmt.Sprintf("message-%d-%d", routine, j)
-						user := fmt.Sprintf("user-%d-%d", routine, j)
-						server := "server1"
-						db.UpdateDatabase(id, user, server, false)
-					}
-				}(i)
-			}
-			wg.Wait()
-			assertStats(t, db, tt.want)
-		})
+	for _, backend := range storageBackends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				if updates := tt.goroutines * tt.opsPerGoroutine; backend.maxUpdates > 0 && updates > backend.maxUpdates {
+					t.Skipf("%d updates is too many for the %s backend", updates, backend.name)
+				}
+				db := backend.open(t)
+				var wg sync.WaitGroup
+				wg.Add(tt.goroutines)
+				for i := 0; i < tt.goroutines; i++ {
+					go func(routine int) {
+						defer wg.Done()
+						for j := 0; j < tt.opsPerGoroutine; j++ {
+							id := fmt.Sprintf("message-%d-%d", routine, j)
+							user := fmt.Sprintf("user-%d-%d", routine, j)
+							server := "server1"
+							db.UpdateDatabase(id, user, server, false)
+						}
+					}(i)
+				}
+				wg.Wait()
+				assertStatsWithin(t, db, tt.want, backend.messageTolerance)
+			})
+		}
+	}
+}
+
+func TestRecordEvent(t *testing.T) {
+	db := NewInMemoryDatabase()
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	events := []models.Event{
+		{ID: "msg1", Time: start, Type: "edit", Wiki: "enwiki", Server: "server1", User: "alice", Title: "Go", OldLength: 100, NewLength: 150},
+		{ID: "msg2", Time: start.Add(time.Minute), Type: "edit", Wiki: "dewiki", Server: "server2", User: "alice", Namespace: 1, Title: "Diskussion:Go", OldLength: 80, NewLength: 50},
+		{ID: "msg3", Time: start.Add(2 * time.Minute), Type: "log", Wiki: "enwiki", Server: "server1", User: "alice", Title: "Special:Log"},
+		{ID: "msg4", Time: start, Type: "edit", Wiki: "enwiki", Server: "server1", User: "bob", Bot: true, Title: "Go"},
+		// Replayed messages must not be counted twice
+		{ID: "msg1", Time: start, Type: "edit", Wiki: "enwiki", Server: "server1", User: "alice", Title: "Go", OldLength: 100, NewLength: 150},
+	}
+	for _, event := range events {
+		db.RecordEvent(event)
+	}
+	assertStats(t, db, wantState{messages: 4, users: 1, bots: 1, servers: 2})
+
+	profile, ok := db.GetUserProfile("alice")
+	if !ok {
+		t.Fatal("Profile for alice not found")
+	}
+	if profile.Events != 3 || profile.Edits != 2 {
+		t.Errorf("events and edits: got %d and %d, want 3 and 2", profile.Events, profile.Edits)
+	}
+	if !profile.FirstSeen.Equal(start) || !profile.LastSeen.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("seen: got %v to %v", profile.FirstSeen, profile.LastSeen)
+	}
+	if profile.BytesAdded != 50 || profile.BytesRemoved != 30 {
+		t.Errorf("bytes: got +%d -%d, want +50 -30", profile.BytesAdded, profile.BytesRemoved)
+	}
+	if profile.Wikis["enwiki"] != 2 || profile.Wikis["dewiki"] != 1 || profile.Namespaces[1] != 1 {
+		t.Errorf("wikis and namespaces: got %v and %v", profile.Wikis, profile.Namespaces)
+	}
+	if profile.Bot {
+		t.Error("alice reported as a bot")
+	}
+	if want := []string{"Diskussion:Go", "Go"}; !slices.Equal(profile.RecentTitles, want) {
+		t.Errorf("recent titles: got %v, want %v", profile.RecentTitles, want)
+	}
+
+	if profile, ok := db.GetUserProfile("bob"); !ok || !profile.Bot {
+		t.Errorf("bob: got %+v, want bot profile", profile)
+	}
+	if _, ok := db.GetUserProfile("corey"); ok {
+		t.Error("Profile found for unknown user")
+	}
+}
+
+func TestRecentTitlesBounded(t *testing.T) {
+	db := NewInMemoryDatabase()
+	for i := 0; i < recentTitleCount+5; i++ {
+		db.RecordEvent(models.Event{ID: fmt.Sprintf("msg%d", i), Type: "edit", User: "alice", Title: fmt.Sprintf("Page %d", i)})
+	}
+	profile, _ := db.GetUserProfile("alice")
+	if len(profile.RecentTitles) != recentTitleCount {
+		t.Fatalf("recent titles: got %d, want %d", len(profile.RecentTitles), recentTitleCount)
+	}
+	if want := fmt.Sprintf("Page %d", recentTitleCount+4); profile.RecentTitles[0] != want {
+		t.Errorf("most recent title: got %s, want %s", profile.RecentTitles[0], want)
+	}
+}
+
+func TestGetPageActivity(t *testing.T) {
+	db := NewInMemoryDatabase()
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	events := []models.Event{
+		{ID: "msg1", Time: start, Type: "new", Wiki: "enwiki", User: "alice", Title: "Go", NewLength: 100, NewRevision: 10},
+		{ID: "msg2", Time: start.Add(time.Minute), Type: "edit", Wiki: "enwiki", User: "bob", Title: "Go", OldLength: 100, NewLength: 80, OldRevision: 10, NewRevision: 12},
+		{ID: "msg3", Time: start.Add(2 * time.Minute), Type: "edit", Wiki: "enwiki", User: "alice", Title: "Go", OldLength: 80, NewLength: 90, OldRevision: 12, NewRevision: 15},
+		{ID: "msg4", Time: start, Type: "edit", Wiki: "dewiki", User: "alice", Title: "Go", OldLength: 5, NewLength: 6, OldRevision: 1, NewRevision: 2},
+		{ID: "msg5", Time: start, Type: "log", Wiki: "enwiki", User: "corey", Title: "Go"},
+	}
+	for _, event := range events {
+		db.RecordEvent(event)
+	}
+
+	page, ok := db.GetPageActivity("enwiki", "Go")
+	if !ok {
+		t.Fatal("Activity for enwiki Go not found")
+	}
+	if page.Edits != 3 || page.Editors != 2 {
+		t.Errorf("edits and editors: got %d and %d, want 3 and 2", page.Edits, page.Editors)
+	}
+	if page.OldestRevision != 10 || page.LatestRevision != 15 {
+		t.Errorf("revisions: got %d to %d, want 10 to 15", page.OldestRevision, page.LatestRevision)
+	}
+	if page.NetBytes != 90 {
+		t.Errorf("net bytes: got %d, want 90", page.NetBytes)
+	}
+	if !page.FirstEdit.Equal(start) || !page.LastEdit.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("edit times: got %v to %v", page.FirstEdit, page.LastEdit)
+	}
+	if len(page.RecentEdits) != 3 || !page.RecentEdits[0].Equal(page.LastEdit) {
+		t.Errorf("recent edits: got %v", page.RecentEdits)
+	}
+	if page, ok := db.GetPageActivity("dewiki", "Go"); !ok || page.Edits != 1 {
+		t.Errorf("dewiki Go: got %+v", page)
+	}
+	if _, ok := db.GetPageActivity("enwiki", "Rust"); ok {
+		t.Error("Activity found for unknown page")
+	}
+}
+
+func TestGetTrending(t *testing.T) {
+	db := NewInMemoryDatabase().WithTrending(5*time.Minute, time.Hour)
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	id := 0
+	edit := func(title string, at time.Time) {
+		id++
+		db.RecordEvent(models.Event{ID: fmt.Sprintf("msg%d", id), Time: at, Type: "edit", Wiki: "enwiki", User: "alice", Title: title})
+	}
+	// A busy page edited steadily for hours, and a quiet page that suddenly gets attention
+	for minute := 0; minute <= 240; minute += 2 {
+		edit("Steady", start.Add(time.Duration(minute)*time.Minute))
+	}
+	edit("Spike", start)
+	for second := 0; second < 120; second += 10 {
+		edit("Spike", start.Add(238*time.Minute+time.Duration(second)*time.Second))
+	}
+	edit("Quiet", start.Add(239*time.Minute))
+
+	trending := db.GetTrending(10)
+	if len(trending) != 2 {
+		t.Fatalf("trending: got %+v, want Spike and Steady", trending)
+	}
+	if trending[0].Title != "Spike" || trending[1].Title != "Steady" {
+		t.Errorf("order: got %s then %s, want Spike then Steady", trending[0].Title, trending[1].Title)
+	}
+	if trending[0].Score <= trending[1].Score || trending[0].CurrentRate <= trending[0].BaselineRate {
+		t.Errorf("Spike not scored above its baseline and Steady: %+v", trending)
+	}
+	// A steady page's current rate should settle near its long-run rate of 30 edits per hour
+	if rate := trending[1].CurrentRate; rate < 20 || rate > 40 {
+		t.Errorf("Steady current rate: got %.1f per hour, want about 30", rate)
+	}
+	if len(db.GetTrending(1)) != 1 {
+		t.Error("Limit not applied")
+	}
+}
+
+func TestTrendingDropsCooledPages(t *testing.T) {
+	db := NewInMemoryDatabase().WithTrending(5*time.Minute, time.Hour)
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		db.RecordEvent(models.Event{ID: fmt.Sprintf("old%d", i), Time: start, Type: "edit", Wiki: "enwiki", User: "alice", Title: "Old"})
+	}
+	if trending := db.GetTrending(0); len(trending) != 1 || trending[0].Title != "Old" {
+		t.Fatalf("trending: got %+v, want Old", trending)
+	}
+	// Hours later the page has cooled off and its baseline has decayed away, but its activity is kept
+	db.RecordEvent(models.Event{ID: "new", Time: start.Add(6 * time.Hour), Type: "edit", Wiki: "enwiki", User: "bob", Title: "New"})
+	if trending := db.GetTrending(0); len(trending) != 0 {
+		t.Errorf("trending: got %+v, want none", trending)
+	}
+	if page, ok := db.GetPageActivity("enwiki", "Old"); !ok || page.Edits != 5 {
+		t.Errorf("quiet page: got %+v, want 5 edits", page)
+	}
+	if usage := db.GetMemoryUsage(); usage.Pages != 2 || len(db.hotPages) != 0 {
+		t.Errorf("pages: got %d with %d candidates, want 2 with none", usage.Pages, len(db.hotPages))
+	}
+}
+
+func TestGetEditorStats(t *testing.T) {
+	db := NewInMemoryDatabase().WithAnonymousPrefixes(24, 48)
+	users := []string{
+		"alice", "alice", "bob",
+		"192.0.2.1", "192.0.2.1", "192.0.2.200", "198.51.100.7",
+		"2001:DB8:1:2:0:0:0:1", "2001:db8:1:3::1",
+		"::ffff:192.0.2.5",
+		"~2025-12345-67",
+	}
+	for i, user := range users {
+		event := models.Event{ID: fmt.Sprintf("msg%d", i), Type: "edit", User: user}
+		if strings.HasPrefix(user, "192.0.2.") {
+			event.Country = "CA"
+		}
+		db.RecordEvent(event)
+	}
+
+	stats := db.GetEditorStats(0)
+	wantEditors := map[models.EditorType]int{models.Registered: 2, models.Anonymous: 6, models.Temporary: 1}
+	wantEdits := map[models.EditorType]int{models.Registered: 3, models.Anonymous: 7, models.Temporary: 1}
+	for editorType, want := range wantEditors {
+		if got := stats.Editors[editorType]; got != want {
+			t.Errorf("%s editors: got %d, want %d", editorType, got, want)
+		}
+		if got := stats.Edits[editorType]; got != wantEdits[editorType] {
+			t.Errorf("%s edits: got %d, want %d", editorType, got, wantEdits[editorType])
+		}
+	}
+	wantPrefixes := []PrefixStats{
+		{Prefix: "192.0.2.0/24", Edits: 4, Addresses: 3},
+		{Prefix: "2001:db8:1::/48", Edits: 2, Addresses: 2},
+		{Prefix: "198.51.100.0/24", Edits: 1, Addresses: 1},
+	}
+	if !slices.Equal(stats.Prefixes, wantPrefixes) {
+		t.Errorf("prefixes: got %+v, want %+v", stats.Prefixes, wantPrefixes)
+	}
+	if !maps.Equal(stats.Countries, map[string]int{"CA": 3}) {
+		t.Errorf("countries: got %v, want CA 3", stats.Countries)
+	}
+	if limited := db.GetEditorStats(1); len(limited.Prefixes) != 1 {
+		t.Errorf("Limit not applied: %+v", limited.Prefixes)
+	}
+}
+
+func TestGetVolumeStats(t *testing.T) {
+	db := NewInMemoryDatabase()
+	start := time.Date(2025, 2, 2, 0, 30, 0, 0, time.UTC)
+	events := []models.Event{
+		{ID: "msg1", Time: start, Type: "new", Wiki: "enwiki", User: "alice", NewLength: 5000},
+		{ID: "msg2", Time: start.Add(10 * time.Minute), Type: "edit", Wiki: "enwiki", User: "bob", Namespace: 1, OldLength: 5000, NewLength: 4950},
+		{ID: "msg3", Time: start.Add(time.Hour), Type: "edit", Wiki: "dewiki", User: "alice", OldLength: 100, NewLength: 105},
+		{ID: "msg4", Time: start.Add(time.Hour), Type: "log", Wiki: "dewiki", User: "corey"},
+		// Edits older than the hourly series still count towards the totals
+		{ID: "msg5", Time: start.Add(-volumeHours * time.Hour), Type: "edit", Wiki: "dewiki", User: "corey", OldLength: 20000, NewLength: 0},
+	}
+	for _, event := range events {
+		db.RecordEvent(event)
+	}
+
+	stats := db.GetVolumeStats(0)
+	if want := (ByteCounts{Edits: 4, Added: 5005, Removed: 20050, Net: -15045}); stats.Total != want {
+		t.Errorf("total: got %+v, want %+v", stats.Total, want)
+	}
+	if want := (ByteCounts{Edits: 2, Added: 5000, Removed: 50, Net: 4950}); stats.Wikis["enwiki"] != want {
+		t.Errorf("enwiki: got %+v, want %+v", stats.Wikis["enwiki"], want)
+	}
+	if want := (ByteCounts{Edits: 1, Removed: 50, Net: -50}); stats.Namespaces[1] != want {
+		t.Errorf("namespace 1: got %+v, want %+v", stats.Namespaces[1], want)
+	}
+	if len(stats.Users) != 3 || stats.Users[0].User != "alice" || stats.Users[0].Added != 5005 {
+		t.Errorf("users: got %+v, want alice first", stats.Users)
+	}
+	counts := make(map[string]int)
+	for _, bucket := range stats.Histogram {
+		counts[bucket.Range] = bucket.Count
+	}
+	wantCounts := map[string]int{"(-inf, -10000]": 1, "(-100, -10]": 1, "(0, 10]": 1, "(1000, 10000]": 1}
+	for bucket, want := range wantCounts {
+		if counts[bucket] != want {
+			t.Errorf("histogram %s: got %d, want %d", bucket, counts[bucket], want)
+		}
+	}
+	if len(stats.Histogram) != len(sizeBuckets)+1 {
+		t.Errorf("histogram buckets: got %d, want %d", len(stats.Histogram), len(sizeBuckets)+1)
+	}
+	if len(stats.Hourly) != 2 || !stats.Hourly[0].Hour.Equal(start.Truncate(time.Hour)) || stats.Hourly[0].Net != 4950 || stats.Hourly[1].Net != 5 {
+		t.Errorf("hourly: got %+v", stats.Hourly)
+	}
+	if limited := db.GetVolumeStats(1); len(limited.Users) != 1 {
+		t.Errorf("Limit not applied: %+v", limited.Users)
+	}
+}
+
+func TestGetBreakdownStats(t *testing.T) {
+	db := NewInMemoryDatabase()
+	events := []models.Event{
+		{ID: "msg1", Type: "edit", Namespace: 0, User: "alice", Minor: true},
+		{ID: "msg2", Type: "edit", Namespace: 0, User: "bob"},
+		{ID: "msg3", Type: "new", Namespace: 0, User: "alice"},
+		{ID: "msg4", Type: "edit", Namespace: 1, User: "bob", Minor: true},
+		{ID: "msg5", Type: "log", Namespace: 2, User: "corey"},
+		{ID: "msg6", Type: "edit", Namespace: 4242, User: "corey"},
+		// Duplicates are not counted twice
+		{ID: "msg1", Type: "edit", Namespace: 0, User: "alice", Minor: true},
+	}
+	for _, event := range events {
+		db.RecordEvent(event)
+	}
+
+	stats := db.GetBreakdownStats()
+	if !maps.Equal(stats.Types, map[string]int{"edit": 4, "new": 1, "log": 1}) {
+		t.Errorf("types: got %v", stats.Types)
+	}
+	if stats.Edits != 4 || stats.MinorEdits != 2 || stats.MinorRatio != 0.5 {
+		t.Errorf("edits: got %d edits, %d minor, ratio %v, want 4, 2, 0.5", stats.Edits, stats.MinorEdits, stats.MinorRatio)
+	}
+	wantNamespaces := []NamespaceStats{
+		{ID: 0, Name: "Main", Events: 3, Edits: 2, MinorEdits: 1, MinorRatio: 0.5},
+		{ID: 1, Name: "Talk", Events: 1, Edits: 1, MinorEdits: 1, MinorRatio: 1},
+		{ID: 2, Name: "User", Events: 1},
+		{ID: 4242, Name: "Namespace 4242", Events: 1, Edits: 1},
+	}
+	if !slices.Equal(stats.Namespaces, wantNamespaces) {
+		t.Errorf("namespaces: got %+v, want %+v", stats.Namespaces, wantNamespaces)
+	}
+}
+
+func TestGetCrossWikiUsers(t *testing.T) {
+	db := NewInMemoryDatabase().WithCrossWikiWindow(time.Hour)
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	events := []models.Event{
+		// alice's first edit falls out of the window
+		{ID: "msg1", Time: start, Type: "edit", Wiki: "frwiki", User: "alice"},
+		{ID: "msg2", Time: start.Add(90 * time.Minute), Type: "edit", Wiki: "enwiki", User: "alice"},
+		{ID: "msg3", Time: start.Add(100 * time.Minute), Type: "edit", Wiki: "dewiki", User: "alice"},
+		{ID: "msg4", Time: start.Add(110 * time.Minute), Type: "new", Wiki: "eswiki", User: "alice"},
+		{ID: "msg5", Time: start.Add(110 * time.Minute), Type: "edit", Wiki: "enwiki", User: "alice"},
+		{ID: "msg6", Time: start.Add(100 * time.Minute), Type: "edit", Wiki: "enwiki", User: "GlobalBot", Bot: true},
+		{ID: "msg7", Time: start.Add(105 * time.Minute), Type: "edit", Wiki: "dewiki", User: "GlobalBot", Bot: true},
+		{ID: "msg8", Time: start.Add(108 * time.Minute), Type: "edit", Wiki: "wikidatawiki", User: "GlobalBot", Bot: true},
+		{ID: "msg9", Time: start.Add(120 * time.Minute), Type: "edit", Wiki: "enwiki", User: "bob"},
+		// Log events such as account creations don't count as activity on a wiki
+		{ID: "msg10", Time: start.Add(120 * time.Minute), Type: "log", Wiki: "dewiki", User: "bob"},
+		{ID: "msg11", Time: start.Add(120 * time.Minute), Type: "log", Wiki: "frwiki", User: "bob"},
+	}
+	for _, event := range events {
+		db.RecordEvent(event)
+	}
+
+	users := db.GetCrossWikiUsers(3, 0)
+	if len(users) != 2 {
+		t.Fatalf("users: got %+v, want alice and GlobalBot", users)
+	}
+	if users[0].User != "alice" || len(users[0].Wikis) != 3 || users[0].Edits != 4 || users[0].Bot {
+		t.Errorf("first user: got %+v, want alice on 3 wikis with 4 edits", users[0])
+	}
+	if _, ok := users[0].Wikis["frwiki"]; ok {
+		t.Error("Edit outside the window counted")
+	}
+	if users[1].User != "GlobalBot" || !users[1].Bot || !users[1].LastSeen.Equal(start.Add(108*time.Minute)) {
+		t.Errorf("second user: got %+v, want GlobalBot", users[1])
+	}
+	if users := db.GetCrossWikiUsers(4, 0); len(users) != 0 {
+		t.Errorf("Minimum not applied: %+v", users)
+	}
+	if users := db.GetCrossWikiUsers(1, 2); len(users) != 2 {
+		t.Errorf("Limit not applied: %+v", users)
+	}
+}
+
+func TestCrossWikiEditsInWindow(t *testing.T) {
+	db := NewInMemoryDatabase().WithCrossWikiWindow(time.Hour)
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	edit := func(id string, minute int, wiki string) {
+		db.RecordEvent(models.Event{ID: id, Time: start.Add(time.Duration(minute) * time.Minute), Type: "edit", Wiki: wiki, User: "alice"})
+	}
+	// enwiki stays in the window through its latest edit, but its first two edits fall out of it
+	edit("msg1", 0, "enwiki")
+	edit("msg2", 5, "enwiki")
+	edit("msg3", 65, "dewiki")
+	edit("msg4", 68, "frwiki")
+	edit("msg5", 70, "enwiki")
+
+	users := db.GetCrossWikiUsers(3, 0)
+	if len(users) != 1 || users[0].Edits != 3 {
+		t.Errorf("users: got %+v, want alice with 3 edits", users)
+	}
+}
+
+func TestGetWikiCounts(t *testing.T) {
+	db := NewInMemoryDatabase()
+	events := []models.Event{
+		{ID: "msg1", Type: "edit", Wiki: "enwiki", User: "alice"},
+		{ID: "msg2", Type: "new", Wiki: "enwiki", User: "SomeBot", Bot: true},
+		{ID: "msg3", Type: "log", Wiki: "enwiki", User: "bob"},
+		{ID: "msg4", Type: "edit", Wiki: "dewiki", User: "alice"},
+		{ID: "msg1", Type: "edit", Wiki: "enwiki", User: "alice"},
+	}
+	for _, event := range events {
+		db.RecordEvent(event)
+	}
+
+	want := map[string]WikiCounts{
+		"enwiki": {Events: 3, Edits: 2, HumanEdits: 1, BotEdits: 1},
+		"dewiki": {Events: 1, Edits: 1, HumanEdits: 1},
+	}
+	if counts := db.GetWikiCounts(); !maps.Equal(counts, want) {
+		t.Errorf("counts: got %+v, want %+v", counts, want)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	db := NewInMemoryDatabase().WithRetention(time.Hour, 3*time.Hour)
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		event models.Event
+		want  wantState
+		// Message IDs held after the event
+		wantIDs int
+	}{
+		{event: models.Event{ID: "msg1", Time: start, User: "alice", Server: "server1"}, want: wantState{messages: 1, users: 1, servers: 1}, wantIDs: 1},
+		{event: models.Event{ID: "msg2", Time: start.Add(30 * time.Minute), User: "bob", Server: "server2", Bot: true}, want: wantState{messages: 2, users: 1, bots: 1, servers: 2}, wantIDs: 2},
+		// Duplicates within the window are recognized
+		{event: models.Event{ID: "msg1", Time: start, User: "alice", Server: "server1"}, want: wantState{messages: 2, users: 1, bots: 1, servers: 2}, wantIDs: 2},
+		// msg1 falls out of the window
+		{event: models.Event{ID: "msg3", Time: start.Add(70 * time.Minute), User: "alice", Server: "server1"}, want: wantState{messages: 3, users: 1, bots: 1, servers: 2}, wantIDs: 2},
+		// Messages older than the window are counted but not kept
+		{event: models.Event{ID: "msg0", Time: start.Add(-time.Hour), User: "alice", Server: "server1"}, want: wantState{messages: 4, users: 1, bots: 1, servers: 2}, wantIDs: 2},
+		// bob and server2 age out of the sets, alice was seen again
+		{event: models.Event{ID: "msg4", Time: start.Add(4 * time.Hour), User: "corey", Server: "server3"}, want: wantState{messages: 5, users: 2, servers: 2}, wantIDs: 1},
+	}
+	for i, step := range steps {
+		db.RecordEvent(step.event)
+		assertStats(t, db, step.want)
+		if usage := db.GetMemoryUsage(); usage.MessageIDs != step.wantIDs {
+			t.Errorf("step %d message IDs: got %d, want %d", i, usage.MessageIDs, step.wantIDs)
+		}
+	}
+
+	usage := db.GetMemoryUsage()
+	if usage.Users != 2 || usage.Bots != 0 || usage.Servers != 2 || usage.Profiles != 2 || usage.HeapBytes == 0 {
+		t.Errorf("memory usage: got %+v", usage)
+	}
+	if _, ok := db.GetUserProfile("bob"); ok {
+		t.Error("Profile not seen within the TTL not forgotten")
+	}
+	if ids := db.RecentMessageIDs(); !slices.Equal(ids, []string{"msg4"}) {
+		t.Errorf("recent message IDs: got %v, want [msg4]", ids)
+	}
+}
+
+func TestRetentionEditors(t *testing.T) {
+	db := NewInMemoryDatabase().WithRetention(time.Hour, 3*time.Hour)
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	edit := func(id string, user string, at time.Duration) {
+		db.RecordEvent(models.Event{ID: id, Time: start.Add(at), Type: "edit", Wiki: "enwiki", User: user, Title: "Go"})
+	}
+	edit("msg1", "192.0.2.1", 0)
+	edit("msg2", "198.51.100.1", 0)
+	edit("msg3", "192.0.2.2", 2*time.Hour)
+	edit("msg4", "alice", 0)
+	edit("msg5", "bob", 4*time.Hour)
+
+	stats := db.GetEditorStats(0)
+	if stats.Editors[models.Anonymous] != 1 || stats.Editors[models.Registered] != 1 {
+		t.Errorf("editors: got %+v, want one anonymous and one registered", stats.Editors)
+	}
+	// Edit counts are totals, but only addresses seen within the TTL are kept
+	if stats.Edits[models.Anonymous] != 3 || stats.Edits[models.Registered] != 2 {
+		t.Errorf("edits: got %+v", stats.Edits)
+	}
+	want := []PrefixStats{{Prefix: "192.0.2.0/24", Edits: 2, Addresses: 1}}
+	if !slices.Equal(stats.Prefixes, want) {
+		t.Errorf("prefixes: got %+v, want %+v", stats.Prefixes, want)
+	}
+}
+
+func TestPageRetention(t *testing.T) {
+	db := NewInMemoryDatabase().WithPageRetention(24 * time.Hour)
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	db.RecordEvent(models.Event{ID: "msg1", Time: start, Type: "edit", Wiki: "enwiki", User: "alice", Title: "Old"})
+	db.RecordEvent(models.Event{ID: "msg2", Time: start.Add(20 * time.Hour), Type: "edit", Wiki: "enwiki", User: "alice", Title: "Recent"})
+	if _, ok := db.GetPageActivity("enwiki", "Old"); !ok {
+		t.Error("Page forgotten within the retention")
+	}
+	db.RecordEvent(models.Event{ID: "msg3", Time: start.Add(30 * time.Hour), Type: "edit", Wiki: "enwiki", User: "bob", Title: "New"})
+	if _, ok := db.GetPageActivity("enwiki", "Old"); ok {
+		t.Error("Page not edited within the retention not forgotten")
+	}
+	for _, title := range []string{"Recent", "New"} {
+		if _, ok := db.GetPageActivity("enwiki", title); !ok {
+			t.Errorf("%s forgotten", title)
+		}
 	}
 }
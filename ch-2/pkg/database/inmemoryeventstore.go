@@ -0,0 +1,100 @@
+package database
+
+import (
+	"slices"
+	"sync"
+	"wikistats/pkg/models"
+)
+
+// InMemoryEventStore keeps the most recent events in a fixed size ring buffer
+type InMemoryEventStore struct {
+	lock     sync.Mutex
+	events   []storedEvent
+	next     int
+	sequence uint64
+}
+
+type storedEvent struct {
+	event    models.Event
+	sequence uint64
+}
+
+func (e storedEvent) cursor() cursor {
+	return cursor{time: e.event.Time, sequence: e.sequence}
+}
+
+// Create a store holding up to capacity events, discarding the oldest when full
+func NewInMemoryEventStore(capacity int) *InMemoryEventStore {
+	return &InMemoryEventStore{
+		events: make([]storedEvent, 0, max(capacity, 1)),
+	}
+}
+
+func (s *InMemoryEventStore) HandleEvent(event models.Event) {
+	s.StoreEvent(event)
+}
+
+func (s *InMemoryEventStore) StoreEvent(event models.Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sequence++
+	stored := storedEvent{event: event, sequence: s.sequence}
+	if len(s.events) < cap(s.events) {
+		s.events = append(s.events, stored)
+		return
+	}
+	s.events[s.next] = stored
+	s.next = (s.next + 1) % len(s.events)
+}
+
+func (s *InMemoryEventStore) QueryEvents(query EventQuery) (EventPage, error) {
+	var after cursor
+	if query.Cursor != "" {
+		var err error
+		if after, err = decodeCursor(query.Cursor); err != nil {
+			return EventPage{}, err
+		}
+	}
+
+	s.lock.Lock()
+	matches := make([]storedEvent, 0)
+	for _, stored := range s.events {
+		if !query.Match(stored.event) {
+			continue
+		}
+		// Skip everything up to and including the cursor position
+		if query.Cursor != "" {
+			if query.Descending && !stored.cursor().before(after) {
+				continue
+			}
+			if !query.Descending && !after.before(stored.cursor()) {
+				continue
+			}
+		}
+		matches = append(matches, stored)
+	}
+	s.lock.Unlock()
+
+	slices.SortFunc(matches, func(a, b storedEvent) int {
+		order := 1
+		if a.cursor().before(b.cursor()) {
+			order = -1
+		}
+		if query.Descending {
+			return -order
+		}
+		return order
+	})
+
+	page := EventPage{Events: make([]models.Event, 0)}
+	limit := query.PageSize()
+	for i, stored := range matches {
+		if i == limit {
+			page.NextCursor = encodeCursor(matches[i-1].cursor())
+			break
+		}
+		page.Events = append(page.Events, stored.event)
+	}
+	return page, nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+	"wikistats/pkg/models"
+)
+
+var baseTime = time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+
+func eventAt(id string, minutes int, wiki string, user string, bot bool) models.Event {
+	return models.Event{
+		ID:   id,
+		Time: baseTime.Add(time.Duration(minutes) * time.Minute),
+		Wiki: wiki,
+		User: user,
+		Bot:  bot,
+		Type: "edit",
+	}
+}
+
+func eventIDs(events []models.Event) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestQueryEvents(t *testing.T) {
+	events := []models.Event{
+		eventAt("msg1", 0, "enwiki", "alice", false),
+		eventAt("msg2", 30, "dewiki", "alice", false),
+		eventAt("msg3", 10, "enwiki", "bob", true),
+		eventAt("msg4", 90, "enwiki", "alice", false),
+	}
+	bot := true
+
+	tests := []struct {
+		name  string
+		query EventQuery
+		want  []string
+	}{
+		{name: "All events sorted by time", query: EventQuery{}, want: []string{"msg1", "msg3", "msg2", "msg4"}},
+		{name: "Descending", query: EventQuery{Descending: true}, want: []string{"msg4", "msg2", "msg3", "msg1"}},
+		{name: "Time range", query: EventQuery{Since: baseTime.Add(10 * time.Minute), Until: baseTime.Add(90 * time.Minute)}, want: []string{"msg3", "msg2"}},
+		{name: "User and wiki", query: EventQuery{Users: []string{"alice"}, Wikis: []string{"enwiki"}}, want: []string{"msg1", "msg4"}},
+		{name: "Bots", query: EventQuery{Bot: &bot}, want: []string{"msg3"}},
+		{name: "Type", query: EventQuery{Types: []string{"log"}}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryEventStore(10)
+			for _, event := range events {
+				store.StoreEvent(event)
+			}
+			page, err := store.QueryEvents(tt.query)
+			if err != nil {
+				t.Fatalf("QueryEvents() error = %v", err)
+			}
+			if got := eventIDs(page.Events); !slices.Equal(got, tt.want) {
+				t.Errorf("events: got %v, want %v", got, tt.want)
+			}
+			if page.NextCursor != "" {
+				t.Errorf("Unexpected next cursor on final page")
+			}
+		})
+	}
+}
+
+func TestQueryEventsPagination(t *testing.T) {
+	for _, descending := range []bool{false, true} {
+		t.Run(fmt.Sprintf("Descending %v", descending), func(t *testing.T) {
+			store := NewInMemoryEventStore(100)
+			want := make([]string, 0)
+			for i := 0; i < 25; i++ {
+				// Several events share each timestamp so the sequence has to break ties
+				store.StoreEvent(eventAt(fmt.Sprintf("msg%d", i), i/3, "enwiki", "alice", false))
+				want = append(want, fmt.Sprintf("msg%d", i))
+			}
+			if descending {
+				slices.Reverse(want)
+			}
+			got := make([]string, 0)
+			query := EventQuery{Limit: 10, Descending: descending}
+			for pages := 0; ; pages++ {
+				if pages > 3 {
+					t.Fatal("Too many pages returned")
+				}
+				page, err := store.QueryEvents(query)
+				if err != nil {
+					t.Fatalf("QueryEvents() error = %v", err)
+				}
+				got = append(got, eventIDs(page.Events)...)
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("events: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestEventStoreCapacity(t *testing.T) {
+	store := NewInMemoryEventStore(3)
+	for i := 0; i < 5; i++ {
+		store.StoreEvent(eventAt(fmt.Sprintf("msg%d", i), i, "enwiki", "alice", false))
+	}
+	page, err := store.QueryEvents(EventQuery{})
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	if got, want := eventIDs(page.Events), []string{"msg2", "msg3", "msg4"}; !slices.Equal(got, want) {
+		t.Errorf("events: got %v, want %v", got, want)
+	}
+}
+
+func TestQueryEventsInvalidCursor(t *testing.T) {
+	store := NewInMemoryEventStore(1)
+	if _, err := store.QueryEvents(EventQuery{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("QueryEvents() error = %v, want %v", err, ErrInvalidCursor)
+	}
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"slices"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	// Number of recent edit times kept for each page
+	recentEditCount = 10
+	// Fraction of the page retention between sweeps for pages to forget
+	pageSweepFraction = 10
+)
+
+// Edit activity on a single page of a wiki
+type PageActivity struct {
+	Wiki           string      `json:"wiki"`
+	Title          string      `json:"title"`
+	Edits          int         `json:"edits"`
+	Editors        int         `json:"editors"`
+	OldestRevision int         `json:"oldest_revision"`
+	LatestRevision int         `json:"latest_revision"`
+	NetBytes       int64       `json:"net_bytes"`
+	FirstEdit      time.Time   `json:"first_edit"`
+	LastEdit       time.Time   `json:"last_edit"`
+	RecentEdits    []time.Time `json:"recent_edits"`
+	editors        map[string]int
+	trend          trendCounters
+}
+
+type pageKey struct {
+	wiki  string
+	title string
+}
+
+func newPageActivity(wiki string, title string) *PageActivity {
+	return &PageActivity{
+		Wiki:        wiki,
+		Title:       title,
+		RecentEdits: make([]time.Time, 0, recentEditCount),
+		editors:     make(map[string]int),
+	}
+}
+
+func (p *PageActivity) record(event models.Event) {
+	p.Edits++
+	p.editors[event.User]++
+	p.Editors = len(p.editors)
+	p.NetBytes += int64(event.ByteDelta())
+	// New pages have no old revision, so their first revision is the new one
+	oldest := event.OldRevision
+	if oldest == 0 {
+		oldest = event.NewRevision
+	}
+	if oldest != 0 && (p.OldestRevision == 0 || oldest < p.OldestRevision) {
+		p.OldestRevision = oldest
+	}
+	p.LatestRevision = max(p.LatestRevision, event.NewRevision)
+	if p.FirstEdit.IsZero() || event.Time.Before(p.FirstEdit) {
+		p.FirstEdit = event.Time
+	}
+	if event.Time.After(p.LastEdit) {
+		p.LastEdit = event.Time
+	}
+	if len(p.RecentEdits) == recentEditCount {
+		p.RecentEdits = p.RecentEdits[:recentEditCount-1]
+	}
+	p.RecentEdits = slices.Insert(p.RecentEdits, 0, event.Time)
+}
+
+// Copy the exported fields so callers can't race with later updates
+func (p *PageActivity) clone() PageActivity {
+	activity := *p
+	activity.RecentEdits = slices.Clone(p.RecentEdits)
+	activity.editors = nil
+	return activity
+}
+
+// Set how long pages are kept after their last edit. Pages are kept forever without a retention
+func (d *InMemoryDatabase) WithPageRetention(retention time.Duration) *InMemoryDatabase {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if retention > 0 {
+		d.pageRetention = retention
+	}
+	return d
+}
+
+// Forget pages not edited within the retention, sweeping only every so often since it visits every
+// page. Caller must hold the lock
+func (d *InMemoryDatabase) expirePages() {
+	if d.pageRetention <= 0 || d.latest.Sub(d.lastPageSweep) < d.pageRetention/pageSweepFraction {
+		return
+	}
+	d.lastPageSweep = d.latest
+	cutoff := d.latest.Add(-d.pageRetention)
+	for key, page := range d.pages {
+		if page.LastEdit.Before(cutoff) {
+			delete(d.pages, key)
+			delete(d.hotPages, key)
+		}
+	}
+}
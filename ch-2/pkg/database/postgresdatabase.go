@@ -0,0 +1,298 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"wikistats/pkg/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	DefaultPostgresBatchSize     = 1000
+	DefaultPostgresFlushInterval = time.Second
+	// Batches kept for retrying while Postgres is unreachable, beyond which the oldest events are dropped
+	postgresMaxPendingBatches = 100
+	postgresTimeout           = 30 * time.Second
+)
+
+// Schema changes in the order they're applied. Append new migrations rather than editing applied ones
+var postgresMigrations = []string{
+	`CREATE TABLE events (
+		id TEXT PRIMARY KEY,
+		time TIMESTAMPTZ,
+		wiki TEXT NOT NULL,
+		server TEXT NOT NULL,
+		type TEXT NOT NULL,
+		namespace INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		user_name TEXT NOT NULL,
+		bot BOOLEAN NOT NULL,
+		minor BOOLEAN NOT NULL,
+		comment TEXT NOT NULL,
+		old_length INTEGER NOT NULL,
+		new_length INTEGER NOT NULL,
+		old_revision BIGINT NOT NULL,
+		new_revision BIGINT NOT NULL,
+		country TEXT NOT NULL
+	)`,
+	`CREATE INDEX events_time ON events (time);
+	CREATE INDEX events_wiki_time ON events (wiki, time);
+	CREATE INDEX events_user_name ON events (user_name)`,
+	// Distinct users, bots and servers and the running counts, kept up to date as events are written so
+	// stats don't have to scan every event
+	`CREATE TABLE event_users (
+		user_name TEXT NOT NULL,
+		bot BOOLEAN NOT NULL,
+		PRIMARY KEY (user_name, bot)
+	);
+	CREATE TABLE event_servers (server TEXT PRIMARY KEY);
+	CREATE TABLE event_counts (
+		only_row BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (only_row),
+		messages BIGINT NOT NULL,
+		users BIGINT NOT NULL,
+		bots BIGINT NOT NULL,
+		servers BIGINT NOT NULL
+	);
+	INSERT INTO event_users SELECT DISTINCT user_name, bot FROM events;
+	INSERT INTO event_servers SELECT DISTINCT server FROM events;
+	INSERT INTO event_counts (messages, users, bots, servers) SELECT
+		(SELECT count(*) FROM events),
+		(SELECT count(*) FROM event_users WHERE NOT bot),
+		(SELECT count(*) FROM event_users WHERE bot),
+		(SELECT count(*) FROM event_servers)`,
+}
+
+// Move the batch into the events table, adding the users, bots and servers it brings and counting
+// only what's new. Concurrent writers wait on each other's conflicting rows, so nothing is counted twice
+const postgresInsertBatch = `WITH inserted AS (
+	INSERT INTO events SELECT * FROM events_batch ON CONFLICT (id) DO NOTHING RETURNING user_name, bot, server
+), new_users AS (
+	INSERT INTO event_users SELECT DISTINCT user_name, bot FROM inserted ON CONFLICT DO NOTHING RETURNING bot
+), new_servers AS (
+	INSERT INTO event_servers SELECT DISTINCT server FROM inserted ON CONFLICT DO NOTHING RETURNING server
+)
+UPDATE event_counts SET
+	messages = messages + (SELECT count(*) FROM inserted),
+	users = users + (SELECT count(*) FROM new_users WHERE NOT bot),
+	bots = bots + (SELECT count(*) FROM new_users WHERE bot),
+	servers = servers + (SELECT count(*) FROM new_servers)`
+
+var postgresEventColumns = []string{
+	"id", "time", "wiki", "server", "type", "namespace", "title", "user_name", "bot", "minor", "comment",
+	"old_length", "new_length", "old_revision", "new_revision", "country",
+}
+
+// PostgresDatabase stores every event in Postgres, so history can be shared and queried, and keeps
+// the core stats in counts updated with each batch. Events are buffered and written in batches with COPY
+type PostgresDatabase struct {
+	pool *pgxpool.Pool
+	// Guards the pending events
+	lock      sync.Mutex
+	pending   []models.Event
+	batchSize int
+	// Serializes flushes so batches are written in order
+	flushLock sync.Mutex
+	stop      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+// Connect to the database at the URL and bring its schema up to date
+func OpenPostgresDatabase(url string) (*PostgresDatabase, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	if err := migratePostgres(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("migrating schema: %w", err)
+	}
+	d := &PostgresDatabase{pool: pool, batchSize: DefaultPostgresBatchSize, stop: make(chan struct{})}
+	d.stopped.Add(1)
+	go d.flushLoop(DefaultPostgresFlushInterval)
+	return d, nil
+}
+
+// Apply the migrations the database hasn't seen yet in one transaction. The advisory lock keeps
+// instances starting together from applying the same migration twice
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('wikistats_migrations'))`); err != nil {
+			return err
+		}
+		rows, _ := tx.Query(ctx, `SELECT version FROM schema_migrations`)
+		applied, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			return err
+		}
+		pending, err := pendingMigrations(applied)
+		if err != nil {
+			return err
+		}
+		for _, version := range pending {
+			if _, err := tx.Exec(ctx, postgresMigrations[version-1]); err != nil {
+				return fmt.Errorf("migration %d: %w", version, err)
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+				return err
+			}
+			log.Printf("Applied Postgres migration %d", version)
+		}
+		return nil
+	})
+}
+
+// Versions of the migrations still to apply, in order, given the versions already applied. A
+// database with versions this build doesn't know was migrated by a newer one, and isn't touched
+func pendingMigrations(applied []int) ([]int, error) {
+	done := make(map[int]bool, len(applied))
+	for _, version := range applied {
+		if version < 1 || version > len(postgresMigrations) {
+			return nil, fmt.Errorf("database has schema version %d, newer than the %d this build knows", version, len(postgresMigrations))
+		}
+		done[version] = true
+	}
+	var pending []int
+	for version := 1; version <= len(postgresMigrations); version++ {
+		if !done[version] {
+			pending = append(pending, version)
+		}
+	}
+	return pending, nil
+}
+
+func (d *PostgresDatabase) UpdateDatabase(id string, user string, server string, isBot bool) {
+	d.RecordEvent(models.Event{ID: id, User: user, Server: server, Bot: isBot})
+}
+
+// Queue the event, writing the queue once it holds a full batch
+func (d *PostgresDatabase) RecordEvent(event models.Event) {
+	d.lock.Lock()
+	d.pending = append(d.pending, event)
+	full := len(d.pending) >= d.batchSize
+	d.lock.Unlock()
+
+	if full {
+		if err := d.flush(); err != nil {
+			log.Printf("Error writing events to Postgres: %v", err)
+		}
+	}
+}
+
+// Write the queued events. COPY can't skip duplicates, so the batch goes through a temporary table
+// and messages already stored are left alone. Failed batches are put back to be retried
+func (d *PostgresDatabase) flush() error {
+	d.flushLock.Lock()
+	defer d.flushLock.Unlock()
+
+	d.lock.Lock()
+	batch := d.pending
+	d.pending = nil
+	d.lock.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	err := pgx.BeginFunc(ctx, d.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `CREATE TEMPORARY TABLE events_batch (LIKE events) ON COMMIT DROP`); err != nil {
+			return err
+		}
+		rows := pgx.CopyFromSlice(len(batch), func(i int) ([]any, error) {
+			return postgresRow(batch[i]), nil
+		})
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"events_batch"}, postgresEventColumns, rows); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, postgresInsertBatch)
+		return err
+	})
+	if err != nil {
+		d.requeue(batch)
+	}
+	return err
+}
+
+// Values for the event in the order of the columns, with events missing a time stored without one
+func postgresRow(event models.Event) []any {
+	var eventTime *time.Time
+	if !event.Time.IsZero() {
+		eventTime = &event.Time
+	}
+	return []any{
+		event.ID, eventTime, event.Wiki, event.Server, event.Type, event.Namespace, event.Title, event.User,
+		event.Bot, event.Minor, event.Comment, event.OldLength, event.NewLength, event.OldRevision,
+		event.NewRevision, event.Country,
+	}
+}
+
+// Put a batch that failed back ahead of the events queued since, dropping the oldest once too many wait
+func (d *PostgresDatabase) requeue(batch []models.Event) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.pending = append(batch, d.pending...)
+	if excess := len(d.pending) - postgresMaxPendingBatches*d.batchSize; excess > 0 {
+		log.Printf("Dropping %d events waiting for Postgres", excess)
+		d.pending = d.pending[excess:]
+	}
+}
+
+func (d *PostgresDatabase) flushLoop(interval time.Duration) {
+	defer d.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			if err := d.flush(); err != nil {
+				log.Printf("Error writing events to Postgres: %v", err)
+			}
+		}
+	}
+}
+
+// Read the running counts of messages and distinct users, bots and servers, after writing any queued events
+func (d *PostgresDatabase) GetStats() (messages int, users int, bots int, servers int) {
+	if err := d.flush(); err != nil {
+		log.Printf("Error writing events to Postgres: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	err := d.pool.QueryRow(ctx, `SELECT messages, users, bots, servers FROM event_counts`).
+		Scan(&messages, &users, &bots, &servers)
+	if err != nil {
+		log.Printf("Error reading stats from Postgres: %v", err)
+	}
+	return messages, users, bots, servers
+}
+
+// Write the queued events and disconnect
+func (d *PostgresDatabase) Close() error {
+	close(d.stop)
+	d.stopped.Wait()
+	err := d.flush()
+	d.pool.Close()
+	return err
+}
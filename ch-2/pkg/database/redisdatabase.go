@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+	"wikistats/pkg/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKeyPrefix = "wikistats:"
+	redisTimeout   = 5 * time.Second
+)
+
+// RedisDatabase keeps the core stats in Redis, or anything else speaking RESP, so replicas consuming
+// the same stream share one set of stats. Every write is idempotent, so a message counted by several
+// replicas is only counted once. Messages go in a HyperLogLog, whose count is an estimate within about
+// 1%, and users, bots and servers in sorted sets scored by when they were last seen
+type RedisDatabase struct {
+	client *redis.Client
+}
+
+// Connect to the server at a URL such as redis://localhost:6379/0?pool_size=20
+func OpenRedisDatabase(url string) (*RedisDatabase, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisDatabase{client: client}, nil
+}
+
+func redisKey(name string) string {
+	return redisKeyPrefix + name
+}
+
+func (d *RedisDatabase) UpdateDatabase(id string, user string, server string, isBot bool) {
+	d.record(id, user, server, isBot, time.Now())
+}
+
+func (d *RedisDatabase) RecordEvent(event models.Event) {
+	seen := event.Time
+	if seen.IsZero() {
+		seen = time.Now()
+	}
+	d.record(event.ID, event.User, event.Server, event.Bot, seen)
+}
+
+// Send every write for the message in one round trip
+func (d *RedisDatabase) record(id string, user string, server string, isBot bool, seen time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	people := redisKey("users")
+	if isBot {
+		people = redisKey("bots")
+	}
+	score := float64(seen.Unix())
+	_, err := d.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.PFAdd(ctx, redisKey("messages"), id)
+		// GT keeps the latest time when replicas or replays write out of order
+		pipe.ZAddGT(ctx, people, redis.Z{Score: score, Member: user})
+		pipe.ZAddGT(ctx, redisKey("servers"), redis.Z{Score: score, Member: server})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error updating Redis: %v", err)
+	}
+}
+
+func (d *RedisDatabase) GetStats() (messages int, users int, bots int, servers int) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var counts [4]*redis.IntCmd
+	_, err := d.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		counts[0] = pipe.PFCount(ctx, redisKey("messages"))
+		counts[1] = pipe.ZCard(ctx, redisKey("users"))
+		counts[2] = pipe.ZCard(ctx, redisKey("bots"))
+		counts[3] = pipe.ZCard(ctx, redisKey("servers"))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error reading stats from Redis: %v", err)
+		return 0, 0, 0, 0
+	}
+	return int(counts[0].Val()), int(counts[1].Val()), int(counts[2].Val()), int(counts[3].Val())
+}
+
+func (d *RedisDatabase) Close() error {
+	return d.client.Close()
+}
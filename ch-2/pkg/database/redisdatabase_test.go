@@ -0,0 +1,62 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// Connect to an in-process Redis server
+func openTestRedis(t *testing.T) Executer {
+	t.Helper()
+	server := miniredis.RunT(t)
+	db, err := OpenRedisDatabase("redis://" + server.Addr())
+	if err != nil {
+		t.Fatalf("OpenRedisDatabase() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRedisDatabaseReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	replicas := make([]*RedisDatabase, 2)
+	for i := range replicas {
+		db, err := OpenRedisDatabase("redis://" + server.Addr())
+		if err != nil {
+			t.Fatalf("OpenRedisDatabase() error = %v", err)
+		}
+		defer db.Close()
+		replicas[i] = db
+	}
+
+	// Both replicas consume the same stream, concurrently and over pooled connections
+	const messages = 10000
+	var wg sync.WaitGroup
+	for _, db := range replicas {
+		for worker := 0; worker < 10; worker++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := worker; i < messages; i += 10 {
+					db.UpdateDatabase(fmt.Sprintf("msg%d", i), fmt.Sprintf("user%d", i%500), fmt.Sprintf("server%d", i%7), i%100 == 0)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i, db := range replicas {
+		gotMessages, gotUsers, gotBots, gotServers := db.GetStats()
+		if math.Abs(float64(gotMessages-messages)) > 0.02*messages {
+			t.Errorf("replica %d messages: got %d, want about %d", i, gotMessages, messages)
+		}
+		// Every hundredth message comes from one of user0, user100, user200, user300 and user400, which are bots
+		if gotUsers != 495 || gotBots != 5 || gotServers != 7 {
+			t.Errorf("replica %d: got %d users, %d bots and %d servers, want 495, 5 and 7", i, gotUsers, gotBots, gotServers)
+		}
+	}
+}
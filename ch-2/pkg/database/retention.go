@@ -0,0 +1,182 @@
+package database
+
+import (
+	"maps"
+	"runtime/metrics"
+	"slices"
+	"time"
+)
+
+const (
+	// Long enough to cover replays from the last snapshot and stream reconnections
+	DefaultMessageRetention = time.Hour
+	messageBuckets          = 12
+	// Fraction of the set TTL between sweeps of the users, bots, servers and per-user aggregates
+	setSweepFraction = 10
+	// Bytes in live and not yet swept heap objects, the same as HeapAlloc, read without stopping the world
+	heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+)
+
+// MemoryReporter is implemented by databases that can report how much they're holding in memory
+type MemoryReporter interface {
+	GetMemoryUsage() MemoryUsage
+}
+
+type MemoryUsage struct {
+	// Bytes allocated on the heap by the whole process
+	HeapBytes uint64 `json:"heap_bytes"`
+	// Message IDs kept to recognize duplicates
+	MessageIDs int `json:"message_ids"`
+	Users      int `json:"users"`
+	Bots       int `json:"bots"`
+	Servers    int `json:"servers"`
+	Profiles   int `json:"profiles"`
+	Pages      int `json:"pages"`
+}
+
+// Message IDs seen within a sliding window, in time buckets that are dropped whole once they fall
+// out of it. The window follows the newest time added
+type messageWindow struct {
+	window  time.Duration
+	width   time.Duration
+	buckets map[int64]map[string]struct{}
+	newest  time.Time
+	size    int
+}
+
+func newMessageWindow(window time.Duration) *messageWindow {
+	return &messageWindow{
+		window:  window,
+		width:   window / messageBuckets,
+		buckets: make(map[int64]map[string]struct{}),
+	}
+}
+
+func (w *messageWindow) contains(id string) bool {
+	for _, bucket := range w.buckets {
+		if _, ok := bucket[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Remember the message, unless it's too old to be kept
+func (w *messageWindow) add(id string, at time.Time) {
+	if at.After(w.newest) {
+		w.newest = at
+		w.expire()
+	}
+	start := at.Truncate(w.width)
+	if w.expired(start) {
+		return
+	}
+	bucket, ok := w.buckets[start.UnixNano()]
+	if !ok {
+		bucket = make(map[string]struct{})
+		w.buckets[start.UnixNano()] = bucket
+	}
+	bucket[id] = struct{}{}
+	w.size++
+}
+
+// Whether the bucket starting at the time ends before the window
+func (w *messageWindow) expired(start time.Time) bool {
+	return !start.Add(w.width).After(w.newest.Add(-w.window))
+}
+
+func (w *messageWindow) expire() {
+	for start, bucket := range w.buckets {
+		if w.expired(time.Unix(0, start)) {
+			w.size -= len(bucket)
+			delete(w.buckets, start)
+		}
+	}
+}
+
+// Every message ID kept, oldest bucket first
+func (w *messageWindow) ids() []string {
+	ids := make([]string, 0, w.size)
+	for _, start := range slices.Sorted(maps.Keys(w.buckets)) {
+		ids = slices.AppendSeq(ids, maps.Keys(w.buckets[start]))
+	}
+	return ids
+}
+
+// Record that the key was seen at the time, keeping the latest time
+func touch(seen map[string]time.Time, key string, at time.Time) {
+	if last, ok := seen[key]; !ok || at.After(last) {
+		seen[key] = at
+	}
+}
+
+// Set how long message IDs are kept for recognizing duplicates, and how long users, bots, servers,
+// profiles, editors and anonymous addresses are kept after they were last seen. They're kept forever
+// without a TTL
+func (d *InMemoryDatabase) WithRetention(messages time.Duration, setTTL time.Duration) *InMemoryDatabase {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if messages > 0 {
+		d.messages = newMessageWindow(messages)
+	}
+	if setTTL > 0 {
+		d.setTTL = setTTL
+	}
+	return d
+}
+
+// Forget users, bots, servers and their profiles and editor counts not seen within the TTL, sweeping
+// only every so often since it visits every entry. Caller must hold the lock
+func (d *InMemoryDatabase) expireSets() {
+	now := d.messages.newest
+	if d.setTTL <= 0 || now.Sub(d.lastSetSweep) < d.setTTL/setSweepFraction {
+		return
+	}
+	d.lastSetSweep = now
+	cutoff := now.Add(-d.setTTL)
+	for _, seen := range []map[string]time.Time{d.users, d.bots, d.servers} {
+		for key, last := range seen {
+			if last.Before(cutoff) {
+				delete(seen, key)
+			}
+		}
+	}
+	for name, profile := range d.profiles {
+		if profile.LastSeen.Before(cutoff) {
+			delete(d.profiles, name)
+		}
+	}
+	d.expireEditors(cutoff)
+}
+
+// Message IDs kept for recognizing duplicates, oldest first, such as for seeding a deduplicator after
+// restoring a snapshot
+func (d *InMemoryDatabase) RecentMessageIDs() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.messages.ids()
+}
+
+func (d *InMemoryDatabase) GetMemoryUsage() MemoryUsage {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	var heap uint64
+	if sample[0].Value.Kind() == metrics.KindUint64 {
+		heap = sample[0].Value.Uint64()
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return MemoryUsage{
+		HeapBytes:  heap,
+		MessageIDs: d.messages.size,
+		Users:      len(d.users),
+		Bots:       len(d.bots),
+		Servers:    len(d.servers),
+		Profiles:   len(d.profiles),
+		Pages:      len(d.pages),
+	}
+}
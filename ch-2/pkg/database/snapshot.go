@@ -0,0 +1,441 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	DefaultSnapshotInterval = 5 * time.Minute
+	// Bumped whenever the snapshot layout changes in a way older code can't read
+	snapshotVersion = 1
+)
+
+// Identifies wikistats snapshot files, followed by the version as a big endian uint32
+var snapshotMagic = []byte("WIKISTATS-SNAPSHOT")
+
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// Where the snapshot was taken, so the stream can resume from the same point
+type SnapshotInfo struct {
+	Time time.Time
+	// Timestamp of the last stream message included in the snapshot
+	Checkpoint string
+	// End of the last WAL record included in the snapshot
+	WAL WALPosition
+}
+
+// Copy of every aggregate with exported fields for gob
+type snapshotState struct {
+	Info         SnapshotInfo
+	MessageCount int
+	// Message IDs by the start of their time bucket
+	MessageBuckets map[int64][]string
+	MessageNewest  time.Time
+	UsersSeen      map[string]time.Time
+	BotsSeen       map[string]time.Time
+	ServersSeen    map[string]time.Time
+	Profiles       []UserProfile
+	Pages          []pageSnapshot
+	Wikis          map[string]WikiCounts
+	Editors        map[models.EditorType]map[string]time.Time
+	EditorEdits    map[models.EditorType]int
+	Prefixes       []prefixSnapshot
+	Countries      map[string]int
+	Volume         volumeSnapshot
+	Breakdown      breakdownSnapshot
+	CrossWiki      map[string]crossWikiSnapshot
+	Latest         time.Time
+}
+
+type counterSnapshot struct {
+	Value   float64
+	Updated time.Time
+}
+
+type pageSnapshot struct {
+	Activity PageActivity
+	Editors  map[string]int
+	Current  counterSnapshot
+	Baseline counterSnapshot
+}
+
+type prefixSnapshot struct {
+	Prefix    netip.Prefix
+	Edits     int
+	Addresses map[netip.Addr]time.Time
+}
+
+type volumeSnapshot struct {
+	Total      ByteCounts
+	Wikis      map[string]ByteCounts
+	Namespaces map[int]ByteCounts
+	Histogram  []int
+	Hourly     []HourlyVolume
+}
+
+type namespaceSnapshot struct {
+	Events     int
+	Edits      int
+	MinorEdits int
+}
+
+type breakdownSnapshot struct {
+	Types      map[string]int
+	Namespaces map[int]namespaceSnapshot
+	Edits      int
+	MinorEdits int
+}
+
+type crossWikiSnapshot struct {
+	Bot bool
+	// Edits to each wiki by the start of their time bucket
+	Buckets map[string]map[int64]int
+	Last    map[string]time.Time
+}
+
+// Write every aggregate to w, tagged with the stream checkpoint and WAL position the state corresponds to
+func (d *InMemoryDatabase) WriteSnapshot(w io.Writer, info SnapshotInfo) error {
+	// Copy under the lock and encode after releasing it so events aren't held up by the encoding
+	state := d.snapshotState()
+	info.Time = time.Now()
+	state.Info = info
+
+	header := binary.BigEndian.AppendUint32(slices.Clone(snapshotMagic), snapshotVersion)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(state)
+}
+
+// Replace every aggregate with the snapshot in r, returning where the snapshot was taken
+func (d *InMemoryDatabase) ReadSnapshot(r io.Reader) (SnapshotInfo, error) {
+	header := make([]byte, len(snapshotMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("reading snapshot header: %w", err)
+	}
+	if !bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) {
+		return SnapshotInfo{}, errors.New("not a wikistats snapshot")
+	}
+	if version := binary.BigEndian.Uint32(header[len(snapshotMagic):]); version != snapshotVersion {
+		return SnapshotInfo{}, fmt.Errorf("%w %d, want %d", ErrSnapshotVersion, version, snapshotVersion)
+	}
+	var state snapshotState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	d.restore(state)
+	return state.Info, nil
+}
+
+func (d *InMemoryDatabase) snapshotState() snapshotState {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	state := snapshotState{
+		MessageCount:   d.messageCount,
+		MessageBuckets: make(map[int64][]string, len(d.messages.buckets)),
+		MessageNewest:  d.messages.newest,
+		UsersSeen:      maps.Clone(d.users),
+		BotsSeen:       maps.Clone(d.bots),
+		ServersSeen:    maps.Clone(d.servers),
+		Profiles:       make([]UserProfile, 0, len(d.profiles)),
+		Pages:          make([]pageSnapshot, 0, len(d.pages)),
+		Wikis:          make(map[string]WikiCounts, len(d.wikis)),
+		Editors:        make(map[models.EditorType]map[string]time.Time, len(d.editors)),
+		EditorEdits:    maps.Clone(d.editorEdits),
+		Prefixes:       make([]prefixSnapshot, 0, len(d.prefixes)),
+		Countries:      maps.Clone(d.countries),
+		Volume: volumeSnapshot{
+			Total:      d.volume.total,
+			Wikis:      make(map[string]ByteCounts, len(d.volume.wikis)),
+			Namespaces: make(map[int]ByteCounts, len(d.volume.namespaces)),
+			Histogram:  slices.Clone(d.volume.histogram),
+			Hourly:     make([]HourlyVolume, 0, len(d.volume.hourly)),
+		},
+		Breakdown: breakdownSnapshot{
+			Types:      maps.Clone(d.breakdown.types),
+			Namespaces: make(map[int]namespaceSnapshot, len(d.breakdown.namespaces)),
+			Edits:      d.breakdown.edits,
+			MinorEdits: d.breakdown.minorEdits,
+		},
+		CrossWiki: make(map[string]crossWikiSnapshot, len(d.crossWiki.users)),
+		Latest:    d.latest,
+	}
+	for start, bucket := range d.messages.buckets {
+		state.MessageBuckets[start] = slices.Collect(maps.Keys(bucket))
+	}
+	for _, profile := range d.profiles {
+		state.Profiles = append(state.Profiles, profile.clone())
+	}
+	for _, page := range d.pages {
+		state.Pages = append(state.Pages, pageSnapshot{
+			Activity: page.clone(),
+			Editors:  maps.Clone(page.editors),
+			Current:  counterSnapshot{Value: page.trend.current.value, Updated: page.trend.current.updated},
+			Baseline: counterSnapshot{Value: page.trend.baseline.value, Updated: page.trend.baseline.updated},
+		})
+	}
+	for wiki, counts := range d.wikis {
+		state.Wikis[wiki] = *counts
+	}
+	for editorType, editors := range d.editors {
+		state.Editors[editorType] = maps.Clone(editors)
+	}
+	for prefix, counts := range d.prefixes {
+		state.Prefixes = append(state.Prefixes, prefixSnapshot{
+			Prefix:    prefix,
+			Edits:     counts.edits,
+			Addresses: maps.Clone(counts.addresses),
+		})
+	}
+	for wiki, counts := range d.volume.wikis {
+		state.Volume.Wikis[wiki] = *counts
+	}
+	for namespace, counts := range d.volume.namespaces {
+		state.Volume.Namespaces[namespace] = *counts
+	}
+	for hour, counts := range d.volume.hourly {
+		state.Volume.Hourly = append(state.Volume.Hourly, HourlyVolume{Hour: hour, ByteCounts: *counts})
+	}
+	for namespace, counts := range d.breakdown.namespaces {
+		state.Breakdown.Namespaces[namespace] = namespaceSnapshot{Events: counts.events, Edits: counts.edits, MinorEdits: counts.minorEdits}
+	}
+	for name, user := range d.crossWiki.users {
+		buckets := make(map[string]map[int64]int, len(user.edits))
+		for wiki, edits := range user.edits {
+			buckets[wiki] = maps.Clone(edits)
+		}
+		state.CrossWiki[name] = crossWikiSnapshot{Bot: user.bot, Buckets: buckets, Last: maps.Clone(user.last)}
+	}
+	return state
+}
+
+func (d *InMemoryDatabase) restore(state snapshotState) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// IDs are added again rather than taking the buckets as they are, in case the retention changed
+	d.messages = newMessageWindow(d.messages.window)
+	d.messageCount = state.MessageCount
+	d.messages.newest = state.MessageNewest
+	for start, ids := range state.MessageBuckets {
+		for _, id := range ids {
+			d.messages.add(id, time.Unix(0, start))
+		}
+	}
+	d.users = seenMap(state.UsersSeen)
+	d.bots = seenMap(state.BotsSeen)
+	d.servers = seenMap(state.ServersSeen)
+	d.lastSetSweep = time.Time{}
+	d.profiles = make(map[string]*UserProfile, len(state.Profiles))
+	for _, profile := range state.Profiles {
+		// Gob leaves empty maps and slices nil
+		if profile.Wikis == nil {
+			profile.Wikis = make(map[string]int)
+		}
+		if profile.Namespaces == nil {
+			profile.Namespaces = make(map[int]int)
+		}
+		profile.RecentTitles = append(make([]string, 0, recentTitleCount), profile.RecentTitles...)
+		d.profiles[profile.Name] = &profile
+	}
+	d.pages = make(map[pageKey]*PageActivity, len(state.Pages))
+	d.hotPages = make(map[pageKey]struct{})
+	d.lastPageSweep = time.Time{}
+	for _, snapshot := range state.Pages {
+		page := &snapshot.Activity
+		page.RecentEdits = append(make([]time.Time, 0, recentEditCount), page.RecentEdits...)
+		page.editors = make(map[string]int, len(snapshot.Editors))
+		maps.Copy(page.editors, snapshot.Editors)
+		page.trend.current = decayedCounter{value: snapshot.Current.Value, updated: snapshot.Current.Updated}
+		page.trend.baseline = decayedCounter{value: snapshot.Baseline.Value, updated: snapshot.Baseline.Updated}
+		key := pageKey{wiki: page.Wiki, title: page.Title}
+		d.pages[key] = page
+		d.markHot(key, page)
+	}
+	d.wikis = make(map[string]*WikiCounts, len(state.Wikis))
+	for wiki, counts := range state.Wikis {
+		d.wikis[wiki] = &counts
+	}
+	d.editors = make(map[models.EditorType]map[string]time.Time, len(state.Editors))
+	for editorType, editors := range state.Editors {
+		d.editors[editorType] = seenMap(editors)
+	}
+	d.editorEdits = make(map[models.EditorType]int)
+	maps.Copy(d.editorEdits, state.EditorEdits)
+	d.prefixes = make(map[netip.Prefix]*prefixCounts, len(state.Prefixes))
+	for _, prefix := range state.Prefixes {
+		addresses := make(map[netip.Addr]time.Time, len(prefix.Addresses))
+		maps.Copy(addresses, prefix.Addresses)
+		d.prefixes[prefix.Prefix] = &prefixCounts{edits: prefix.Edits, addresses: addresses}
+	}
+	d.countries = make(map[string]int)
+	maps.Copy(d.countries, state.Countries)
+
+	d.volume = newVolumeCounts()
+	d.volume.total = state.Volume.Total
+	for wiki, counts := range state.Volume.Wikis {
+		d.volume.wikis[wiki] = &counts
+	}
+	for namespace, counts := range state.Volume.Namespaces {
+		d.volume.namespaces[namespace] = &counts
+	}
+	copy(d.volume.histogram, state.Volume.Histogram)
+	for _, hourly := range state.Volume.Hourly {
+		d.volume.hourly[hourly.Hour] = &hourly.ByteCounts
+	}
+
+	d.breakdown = newBreakdownCounts()
+	maps.Copy(d.breakdown.types, state.Breakdown.Types)
+	for namespace, counts := range state.Breakdown.Namespaces {
+		d.breakdown.namespaces[namespace] = &namespaceCounts{events: counts.Events, edits: counts.Edits, minorEdits: counts.MinorEdits}
+	}
+	d.breakdown.edits = state.Breakdown.Edits
+	d.breakdown.minorEdits = state.Breakdown.MinorEdits
+
+	d.crossWiki.users = make(map[string]*crossWikiUser, len(state.CrossWiki))
+	for name, user := range state.CrossWiki {
+		// Edits are bucketed again in case the window changed
+		restored := newCrossWikiUser(user.Bot)
+		for wiki, buckets := range user.Buckets {
+			for start, edits := range buckets {
+				restored.add(wiki, time.Unix(0, start), edits, d.crossWiki.bucketWidth())
+			}
+		}
+		maps.Copy(restored.last, user.Last)
+		d.crossWiki.users[name] = restored
+	}
+	d.latest = state.Latest
+}
+
+// Gob leaves empty maps nil
+func seenMap(seen map[string]time.Time) map[string]time.Time {
+	if seen == nil {
+		return make(map[string]time.Time)
+	}
+	return seen
+}
+
+// Write a snapshot to filename atomically, by writing a temporary file in the same directory,
+// syncing it and renaming it over the previous snapshot
+func SaveSnapshot(filename string, db *InMemoryDatabase, info SnapshotInfo) error {
+	dir := filepath.Dir(filename)
+	file, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Removing fails harmlessly once the file has been renamed
+	defer os.Remove(file.Name())
+
+	writer := bufio.NewWriter(file)
+	if err := db.WriteSnapshot(writer, info); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return err
+	}
+	// Sync the directory so the rename itself survives a crash
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+	return nil
+}
+
+// Restore the database from the snapshot file, returning os.ErrNotExist when there isn't one
+func LoadSnapshot(filename string, db *InMemoryDatabase) (SnapshotInfo, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer file.Close()
+
+	return db.ReadSnapshot(bufio.NewReader(file))
+}
+
+// Snapshotter saves the database periodically and once more when stopped
+type Snapshotter struct {
+	db         *InMemoryDatabase
+	filename   string
+	checkpoint func() string
+	recorder   *WALRecorder
+}
+
+// Create a snapshotter tagging each snapshot with the stream checkpoint returned by checkpoint
+func NewSnapshotter(db *InMemoryDatabase, filename string, checkpoint func() string) *Snapshotter {
+	return &Snapshotter{db: db, filename: filename, checkpoint: checkpoint}
+}
+
+// Record the WAL position each snapshot covers, and delete WAL segments once a snapshot covers them
+func (s *Snapshotter) WithWAL(recorder *WALRecorder) *Snapshotter {
+	s.recorder = recorder
+	return s
+}
+
+// Read the checkpoint and WAL position before copying the database, so the snapshot holds at least every
+// message up to them. Messages after them are replayed on restart and ignored as duplicates
+func (s *Snapshotter) Save() error {
+	info := SnapshotInfo{Checkpoint: s.checkpoint()}
+	if s.recorder != nil {
+		info.WAL = s.recorder.Applied()
+	}
+	if err := SaveSnapshot(s.filename, s.db, info); err != nil {
+		return err
+	}
+	if s.recorder != nil {
+		return s.recorder.wal.Compact(info.WAL)
+	}
+	return nil
+}
+
+// Save every interval until the context is canceled, then save a final snapshot
+func (s *Snapshotter) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Save(); err != nil {
+				log.Printf("Error saving final snapshot: %v", err)
+			}
+			return
+		case <-ticker.C:
+			start := time.Now()
+			if err := s.Save(); err != nil {
+				log.Printf("Error saving snapshot: %v", err)
+				continue
+			}
+			log.Printf("Saved snapshot to %s in %v", s.filename, time.Since(start))
+		}
+	}
+}
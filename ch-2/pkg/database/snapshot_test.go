@@ -0,0 +1,120 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+	"wikistats/pkg/models"
+)
+
+func snapshotEvents() []models.Event {
+	start := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	return []models.Event{
+		{ID: "msg1", Time: start, Type: "new", Wiki: "enwiki", Server: "en.wikipedia.org", Title: "Go", User: "alice", NewLength: 500, NewRevision: 10},
+		{ID: "msg2", Time: start.Add(time.Minute), Type: "edit", Wiki: "enwiki", Server: "en.wikipedia.org", Title: "Go", User: "192.0.2.1", Country: "CA", OldLength: 500, NewLength: 450, OldRevision: 10, NewRevision: 11},
+		{ID: "msg3", Time: start.Add(2 * time.Minute), Type: "edit", Wiki: "dewiki", Server: "de.wikipedia.org", Title: "Go", User: "alice", Minor: true, Namespace: 1, OldLength: 100, NewLength: 120},
+		{ID: "msg4", Time: start.Add(3 * time.Minute), Type: "edit", Wiki: "frwiki", Server: "fr.wikipedia.org", Title: "Go", User: "alice", OldLength: 100, NewLength: 90},
+		{ID: "msg5", Time: start.Add(3 * time.Minute), Type: "edit", Wiki: "enwiki", Server: "en.wikipedia.org", Title: "Go", User: "SomeBot", Bot: true, OldLength: 450, NewLength: 460},
+		{ID: "msg6", Time: start.Add(4 * time.Minute), Type: "log", Wiki: "enwiki", Server: "en.wikipedia.org", User: "~2025-1"},
+	}
+}
+
+// Every view of the database, for comparing two databases
+func databaseViews(db *InMemoryDatabase) []any {
+	messages, users, bots, servers := db.GetStats()
+	alice, _ := db.GetUserProfile("alice")
+	page, _ := db.GetPageActivity("enwiki", "Go")
+	return []any{
+		[]int{messages, users, bots, servers},
+		alice,
+		page,
+		db.GetTrending(0),
+		db.GetEditorStats(0),
+		db.GetVolumeStats(0),
+		db.GetBreakdownStats(),
+		db.GetCrossWikiUsers(2, 0),
+		db.GetWikiCounts(),
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	db := NewInMemoryDatabase()
+	for _, event := range snapshotEvents() {
+		db.RecordEvent(event)
+	}
+	filename := filepath.Join(t.TempDir(), "wikistats.snapshot")
+	if err := SaveSnapshot(filename, db, SnapshotInfo{Checkpoint: "2025-02-02T00:04:00Z"}); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	restored := NewInMemoryDatabase()
+	info, err := LoadSnapshot(filename, restored)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if info.Checkpoint != "2025-02-02T00:04:00Z" {
+		t.Errorf("checkpoint: got %q", info.Checkpoint)
+	}
+	want, got := databaseViews(db), databaseViews(restored)
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("view %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Messages replayed from the checkpoint are recognized as duplicates
+	for _, event := range snapshotEvents() {
+		restored.RecordEvent(event)
+	}
+	if messages, _, _, _ := restored.GetStats(); messages != len(snapshotEvents()) {
+		t.Errorf("messages after replay: got %d, want %d", messages, len(snapshotEvents()))
+	}
+	if profile, _ := restored.GetUserProfile("alice"); profile.Edits != 3 {
+		t.Errorf("alice edits after replay: got %d, want 3", profile.Edits)
+	}
+	if matches, _ := filepath.Glob(filename + ".tmp*"); len(matches) != 0 {
+		t.Errorf("Temporary files left behind: %v", matches)
+	}
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	header := binary.BigEndian.AppendUint32(slices.Clone(snapshotMagic), snapshotVersion+1)
+	tests := []struct {
+		name     string
+		contents []byte
+	}{
+		{name: "Empty", contents: nil},
+		{name: "Not a snapshot", contents: []byte("some other file entirely")},
+		{name: "Newer version", contents: header},
+		{name: "Truncated", contents: binary.BigEndian.AppendUint32(slices.Clone(snapshotMagic), snapshotVersion)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDatabase()
+			db.RecordEvent(snapshotEvents()[0])
+			if _, err := db.ReadSnapshot(bytes.NewReader(tt.contents)); err == nil {
+				t.Fatal("Expected error")
+			}
+			// The database is untouched by a failed restore
+			if messages, _, _, _ := db.GetStats(); messages != 1 {
+				t.Errorf("messages: got %d, want 1", messages)
+			}
+		})
+	}
+
+	if _, err := LoadSnapshot(filepath.Join(t.TempDir(), "missing"), NewInMemoryDatabase()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Missing file: got %v, want os.ErrNotExist", err)
+	}
+	var buf bytes.Buffer
+	buf.Write(header)
+	if _, err := NewInMemoryDatabase().ReadSnapshot(&buf); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("Newer version: got %v, want ErrSnapshotVersion", err)
+	}
+}
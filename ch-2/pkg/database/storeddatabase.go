@@ -0,0 +1,72 @@
+package database
+
+import (
+	"fmt"
+	"wikistats/pkg/models"
+)
+
+// Storage backends that can hold the core stats
+const (
+	BackendMemory   = "memory"
+	BackendBolt     = "bolt"
+	BackendPostgres = "postgres"
+	BackendRedis    = "redis"
+)
+
+// Store is a storage backend for the core stats that holds resources until closed
+type Store interface {
+	Executer
+	Close() error
+}
+
+// Open the named storage backend, where location is the backend's file or connection string
+func OpenStore(backend string, location string) (Store, error) {
+	switch backend {
+	case BackendBolt:
+		if location == "" {
+			return nil, fmt.Errorf("the %s backend needs a file", backend)
+		}
+		return OpenBoltDatabase(location)
+	case BackendPostgres:
+		if location == "" {
+			return nil, fmt.Errorf("the %s backend needs a connection URL", backend)
+		}
+		return OpenPostgresDatabase(location)
+	case BackendRedis:
+		if location == "" {
+			return nil, fmt.Errorf("the %s backend needs a server URL", backend)
+		}
+		return OpenRedisDatabase(location)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// StoredDatabase keeps the core stats in a storage backend and every other aggregate in memory
+type StoredDatabase struct {
+	*InMemoryDatabase
+	store Executer
+}
+
+func NewStoredDatabase(store Executer, aggregates *InMemoryDatabase) *StoredDatabase {
+	return &StoredDatabase{InMemoryDatabase: aggregates, store: store}
+}
+
+func (d *StoredDatabase) UpdateDatabase(id string, user string, server string, isBot bool) {
+	d.store.UpdateDatabase(id, user, server, isBot)
+	d.InMemoryDatabase.UpdateDatabase(id, user, server, isBot)
+}
+
+// Stores that keep whole events are given the event rather than just the core stats
+func (d *StoredDatabase) RecordEvent(event models.Event) {
+	if recorder, ok := d.store.(EventRecorder); ok {
+		recorder.RecordEvent(event)
+	} else {
+		d.store.UpdateDatabase(event.ID, event.User, event.Server, event.Bot)
+	}
+	d.InMemoryDatabase.RecordEvent(event)
+}
+
+func (d *StoredDatabase) GetStats() (messages int, users int, bots int, servers int) {
+	return d.store.GetStats()
+}
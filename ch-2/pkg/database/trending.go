@@ -0,0 +1,78 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+const (
+	DefaultTrendingWindow   = 5 * time.Minute
+	DefaultTrendingHalfLife = 6 * time.Hour
+	// Pages need roughly this many recent edits before they can trend, to ignore single edits to quiet pages
+	trendingMinEdits = 3
+)
+
+// A page whose recent edit rate is high relative to its baseline
+type TrendingPage struct {
+	Wiki         string  `json:"wiki"`
+	Title        string  `json:"title"`
+	Score        float64 `json:"score"`
+	CurrentRate  float64 `json:"current_edits_per_hour"`
+	BaselineRate float64 `json:"baseline_edits_per_hour"`
+	Edits        int     `json:"edits"`
+}
+
+// Decayed edit counts over the short trending window and the long baseline half-life
+type trendCounters struct {
+	current  decayedCounter
+	baseline decayedCounter
+}
+
+func (c *trendCounters) add(t time.Time, window time.Duration, halfLife time.Duration) {
+	c.current.add(t, window)
+	c.baseline.add(t, halfLife)
+}
+
+// Ratio of the current rate to the baseline rate, with the baseline padded by one edit per
+// half-life so pages without history don't get unbounded scores
+func (c *trendCounters) score(now time.Time, window time.Duration, halfLife time.Duration) (score float64, current float64, baseline float64) {
+	current = c.current.rate(now, window)
+	baseline = c.baseline.rate(now, halfLife)
+	return current / (baseline + 1/halfLife.Seconds()), current, baseline
+}
+
+// Track the page as a trending candidate while it has enough recent edits. Caller must hold the lock
+func (d *InMemoryDatabase) markHot(key pageKey, page *PageActivity) {
+	if page.trend.current.at(d.latest, d.trendingWindow) >= trendingMinEdits {
+		d.hotPages[key] = struct{}{}
+	}
+}
+
+// Score only the candidates, dropping those that have cooled down. Caller must hold the lock
+func (d *InMemoryDatabase) trending(limit int) []TrendingPage {
+	trending := make([]TrendingPage, 0)
+	for key := range d.hotPages {
+		page, ok := d.pages[key]
+		if !ok || page.trend.current.at(d.latest, d.trendingWindow) < trendingMinEdits {
+			delete(d.hotPages, key)
+			continue
+		}
+		score, current, baseline := page.trend.score(d.latest, d.trendingWindow, d.trendingHalfLife)
+		trending = append(trending, TrendingPage{
+			Wiki:         page.Wiki,
+			Title:        page.Title,
+			Score:        score,
+			CurrentRate:  current * time.Hour.Seconds(),
+			BaselineRate: baseline * time.Hour.Seconds(),
+			Edits:        page.Edits,
+		})
+	}
+	slices.SortFunc(trending, func(a, b TrendingPage) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if limit > 0 && len(trending) > limit {
+		trending = trending[:limit]
+	}
+	return trending
+}
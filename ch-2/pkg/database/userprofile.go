@@ -0,0 +1,71 @@
+package database
+
+import (
+	"maps"
+	"slices"
+	"time"
+	"wikistats/pkg/models"
+)
+
+// Number of recently edited titles kept for each user
+const recentTitleCount = 10
+
+// Everything the database knows about a single user
+type UserProfile struct {
+	Name         string         `json:"name"`
+	FirstSeen    time.Time      `json:"first_seen"`
+	LastSeen     time.Time      `json:"last_seen"`
+	Events       int            `json:"events"`
+	Edits        int            `json:"edits"`
+	Bot          bool           `json:"bot"`
+	Wikis        map[string]int `json:"wikis"`
+	Namespaces   map[int]int    `json:"namespaces"`
+	BytesAdded   int64          `json:"bytes_added"`
+	BytesRemoved int64          `json:"bytes_removed"`
+	RecentTitles []string       `json:"recent_titles"`
+}
+
+func newUserProfile(name string) *UserProfile {
+	return &UserProfile{
+		Name:         name,
+		Wikis:        make(map[string]int),
+		Namespaces:   make(map[int]int),
+		RecentTitles: make([]string, 0, recentTitleCount),
+	}
+}
+
+func (p *UserProfile) record(event models.Event) {
+	if p.FirstSeen.IsZero() || event.Time.Before(p.FirstSeen) {
+		p.FirstSeen = event.Time
+	}
+	if event.Time.After(p.LastSeen) {
+		p.LastSeen = event.Time
+	}
+	p.Events++
+	p.Bot = p.Bot || event.Bot
+	p.Wikis[event.Wiki]++
+	p.Namespaces[event.Namespace]++
+	if !event.IsEdit() {
+		return
+	}
+	p.Edits++
+	if delta := event.ByteDelta(); delta > 0 {
+		p.BytesAdded += int64(delta)
+	} else {
+		p.BytesRemoved += int64(-delta)
+	}
+	// Keep the most recent titles first
+	if len(p.RecentTitles) == recentTitleCount {
+		p.RecentTitles = p.RecentTitles[:recentTitleCount-1]
+	}
+	p.RecentTitles = slices.Insert(p.RecentTitles, 0, event.Title)
+}
+
+// Deep copy so callers can't race with later updates
+func (p *UserProfile) clone() UserProfile {
+	profile := *p
+	profile.Wikis = maps.Clone(p.Wikis)
+	profile.Namespaces = maps.Clone(p.Namespaces)
+	profile.RecentTitles = slices.Clone(p.RecentTitles)
+	return profile
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+	"wikistats/pkg/models"
+)
+
+// Hours of content volume kept for the hourly series
+const volumeHours = 48
+
+// Upper bounds of the edit size histogram buckets in bytes, with a final bucket for larger edits
+var sizeBuckets = []int{-10000, -1000, -100, -10, 0, 10, 100, 1000, 10000}
+
+// Bytes added and removed by a set of edits
+type ByteCounts struct {
+	Edits   int   `json:"edits"`
+	Added   int64 `json:"bytes_added"`
+	Removed int64 `json:"bytes_removed"`
+	Net     int64 `json:"net_bytes"`
+}
+
+func (c *ByteCounts) add(delta int) {
+	c.Edits++
+	if delta > 0 {
+		c.Added += int64(delta)
+	} else {
+		c.Removed += int64(-delta)
+	}
+	c.Net += int64(delta)
+}
+
+// Number of edits whose size change falls in a range
+type HistogramBucket struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
+
+// Content volume for the hour starting at Hour
+type HourlyVolume struct {
+	Hour time.Time `json:"hour"`
+	ByteCounts
+}
+
+// A user and the content they have added and removed
+type UserVolume struct {
+	User string `json:"user"`
+	ByteCounts
+}
+
+// Content added and removed overall, per wiki, namespace, user and hour, and the distribution of edit sizes
+type VolumeStats struct {
+	Total      ByteCounts            `json:"total"`
+	Wikis      map[string]ByteCounts `json:"wikis"`
+	Namespaces map[int]ByteCounts    `json:"namespaces"`
+	Users      []UserVolume          `json:"users"`
+	Histogram  []HistogramBucket     `json:"histogram"`
+	Hourly     []HourlyVolume        `json:"hourly"`
+}
+
+type volumeCounts struct {
+	total      ByteCounts
+	wikis      map[string]*ByteCounts
+	namespaces map[int]*ByteCounts
+	histogram  []int
+	hourly     map[time.Time]*ByteCounts
+}
+
+func newVolumeCounts() *volumeCounts {
+	return &volumeCounts{
+		wikis:      make(map[string]*ByteCounts),
+		namespaces: make(map[int]*ByteCounts),
+		histogram:  make([]int, len(sizeBuckets)+1),
+		hourly:     make(map[time.Time]*ByteCounts),
+	}
+}
+
+// Record an edit, using latest as the current time for expiring old hours
+func (v *volumeCounts) record(event models.Event, latest time.Time) {
+	delta := event.ByteDelta()
+	v.total.add(delta)
+	addTo(v.wikis, event.Wiki, delta)
+	addTo(v.namespaces, event.Namespace, delta)
+	bucket, _ := slices.BinarySearch(sizeBuckets, delta)
+	v.histogram[bucket]++
+
+	hour := event.Time.Truncate(time.Hour)
+	cutoff := latest.Truncate(time.Hour).Add(-(volumeHours - 1) * time.Hour)
+	if hour.Before(cutoff) {
+		return
+	}
+	if _, ok := v.hourly[hour]; !ok {
+		maps.DeleteFunc(v.hourly, func(h time.Time, _ *ByteCounts) bool {
+			return h.Before(cutoff)
+		})
+	}
+	addTo(v.hourly, hour, delta)
+}
+
+func addTo[K comparable](counts map[K]*ByteCounts, key K, delta int) {
+	if counts[key] == nil {
+		counts[key] = &ByteCounts{}
+	}
+	counts[key].add(delta)
+}
+
+func bucketRange(i int) string {
+	switch i {
+	case 0:
+		return fmt.Sprintf("(-inf, %d]", sizeBuckets[0])
+	case len(sizeBuckets):
+		return fmt.Sprintf("(%d, inf)", sizeBuckets[len(sizeBuckets)-1])
+	}
+	return fmt.Sprintf("(%d, %d]", sizeBuckets[i-1], sizeBuckets[i])
+}
+
+// Volume stats including the users who have added the most content, at most limit when limit is positive
+func (d *InMemoryDatabase) GetVolumeStats(limit int) VolumeStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	stats := VolumeStats{
+		Total:      d.volume.total,
+		Wikis:      make(map[string]ByteCounts, len(d.volume.wikis)),
+		Namespaces: make(map[int]ByteCounts, len(d.volume.namespaces)),
+		Users:      make([]UserVolume, 0),
+		Histogram:  make([]HistogramBucket, 0, len(d.volume.histogram)),
+		Hourly:     make([]HourlyVolume, 0, len(d.volume.hourly)),
+	}
+	for wiki, counts := range d.volume.wikis {
+		stats.Wikis[wiki] = *counts
+	}
+	for namespace, counts := range d.volume.namespaces {
+		stats.Namespaces[namespace] = *counts
+	}
+	for name, profile := range d.profiles {
+		if profile.Edits == 0 {
+			continue
+		}
+		stats.Users = append(stats.Users, UserVolume{User: name, ByteCounts: ByteCounts{
+			Edits:   profile.Edits,
+			Added:   profile.BytesAdded,
+			Removed: profile.BytesRemoved,
+			Net:     profile.BytesAdded - profile.BytesRemoved,
+		}})
+	}
+	slices.SortFunc(stats.Users, func(a, b UserVolume) int {
+		if order := cmp.Compare(b.Added, a.Added); order != 0 {
+			return order
+		}
+		return cmp.Compare(a.User, b.User)
+	})
+	if limit > 0 && len(stats.Users) > limit {
+		stats.Users = stats.Users[:limit]
+	}
+	for i, count := range d.volume.histogram {
+		stats.Histogram = append(stats.Histogram, HistogramBucket{Range: bucketRange(i), Count: count})
+	}
+	for hour, counts := range d.volume.hourly {
+		stats.Hourly = append(stats.Hourly, HourlyVolume{Hour: hour, ByteCounts: *counts})
+	}
+	slices.SortFunc(stats.Hourly, func(a, b HourlyVolume) int {
+		return a.Hour.Compare(b.Hour)
+	})
+	return stats
+}
@@ -0,0 +1,468 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	DefaultSegmentSize  = 64 << 20
+	DefaultSyncInterval = time.Second
+	walExtension        = ".wal"
+	// Length and CRC before each record's payload
+	walHeaderSize = 8
+	// Records larger than this are treated as corruption rather than allocated
+	maxWALRecord = 16 << 20
+)
+
+// When appended records are flushed to stable storage
+type SyncPolicy string
+
+const (
+	// Sync after every record, so a crash loses nothing that was appended
+	SyncAlways SyncPolicy = "always"
+	// Sync in the background every sync interval, so a crash loses at most one interval
+	SyncInterval SyncPolicy = "interval"
+	// Write records to the file every sync interval but leave flushing them to disk to the operating system
+	SyncNever SyncPolicy = "never"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptRecord = errors.New("corrupt WAL record")
+
+// Location in the log just after a record
+type WALPosition struct {
+	Segment uint64
+	Offset  int64
+}
+
+func (p WALPosition) Before(other WALPosition) bool {
+	return p.Segment < other.Segment || p.Segment == other.Segment && p.Offset < other.Offset
+}
+
+// WAL is an append-only log of events split into numbered segment files, where each record is
+// its payload length and CRC-32C followed by the event as JSON
+type WAL struct {
+	lock         sync.Mutex
+	dir          string
+	segmentSize  int64
+	policy       SyncPolicy
+	file         *os.File
+	writer       *bufio.Writer
+	position     WALPosition
+	dirty        bool
+	stop         chan struct{}
+	stopped      sync.WaitGroup
+	syncInterval time.Duration
+}
+
+// Open the log in dir, creating it if needed and cutting off any partly written record at the end
+// left by a crash, so appends continue after the last complete record
+func OpenWAL(dir string, segmentSize int64, policy SyncPolicy, syncInterval time.Duration) (*WAL, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if syncInterval <= 0 {
+		syncInterval = DefaultSyncInterval
+	}
+	switch policy {
+	case SyncAlways, SyncInterval, SyncNever:
+	case "":
+		policy = SyncInterval
+	default:
+		return nil, fmt.Errorf("unknown WAL sync policy %q", policy)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &WAL{dir: dir, segmentSize: segmentSize, policy: policy, syncInterval: syncInterval, stop: make(chan struct{})}
+
+	segments, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	segment := uint64(1)
+	if len(segments) > 0 {
+		segment = segments[len(segments)-1]
+	}
+	offset, err := w.validLength(segment)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.openSegment(segment, offset); err != nil {
+		return nil, err
+	}
+	if policy != SyncAlways {
+		w.stopped.Add(1)
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+func (w *WAL) segmentPath(segment uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%016d%s", segment, walExtension))
+}
+
+// Numbers of the segment files in order
+func (w *WAL) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]uint64, 0)
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), walExtension)
+		if !ok {
+			continue
+		}
+		if segment, err := strconv.ParseUint(name, 10, 64); err == nil {
+			segments = append(segments, segment)
+		}
+	}
+	slices.Sort(segments)
+	return segments, nil
+}
+
+// Length of the segment up to the end of its last complete record
+func (w *WAL) validLength(segment uint64) (int64, error) {
+	file, err := os.Open(w.segmentPath(segment))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		size, err := skipRecord(reader)
+		if errors.Is(err, io.EOF) {
+			return offset, nil
+		}
+		if errors.Is(err, errCorruptRecord) || errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("Discarding partial record at %s offset %d", w.segmentPath(segment), offset)
+			return offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	}
+}
+
+// Open the segment for appending at offset, discarding anything after it
+func (w *WAL) openSegment(segment uint64, offset int64) error {
+	file, err := os.OpenFile(w.segmentPath(segment), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.writer = bufio.NewWriter(file)
+	w.position = WALPosition{Segment: segment, Offset: offset}
+	return nil
+}
+
+// Append the event, returning the position just after it
+func (w *WAL) Append(event models.Event) (WALPosition, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return WALPosition{}, err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	size := int64(walHeaderSize + len(payload))
+	if w.position.Offset > 0 && w.position.Offset+size > w.segmentSize {
+		if err := w.rotate(); err != nil {
+			return WALPosition{}, err
+		}
+	}
+	header := make([]byte, walHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(payload, crcTable))
+	if _, err := w.writer.Write(header); err != nil {
+		return WALPosition{}, err
+	}
+	if _, err := w.writer.Write(payload); err != nil {
+		return WALPosition{}, err
+	}
+	w.position.Offset += size
+	w.dirty = true
+	if w.policy == SyncAlways {
+		if err := w.sync(); err != nil {
+			return WALPosition{}, err
+		}
+	}
+	return w.position, nil
+}
+
+// Finish the current segment and start the next. Caller must hold the lock
+func (w *WAL) rotate() error {
+	if err := w.sync(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return w.openSegment(w.position.Segment+1, 0)
+}
+
+// Flush buffered records and, unless syncing is left to the operating system, fsync them. Caller must hold the lock
+func (w *WAL) sync() error {
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	w.dirty = false
+	if w.policy == SyncNever {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+func (w *WAL) syncLoop() {
+	defer w.stopped.Done()
+	ticker := time.NewTicker(w.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.lock.Lock()
+			if w.dirty {
+				if err := w.sync(); err != nil {
+					log.Printf("Error syncing WAL: %v", err)
+				}
+			}
+			w.lock.Unlock()
+		}
+	}
+}
+
+// Sync outstanding records and close the log
+func (w *WAL) Close() error {
+	close(w.stop)
+	w.stopped.Wait()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Position just after the last appended record
+func (w *WAL) Position() WALPosition {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.position
+}
+
+// Pass every event after the position to apply in order, returning how many were replayed. Corrupt
+// records end replay of their segment, since nothing after them can be trusted to be aligned
+func (w *WAL) Replay(from WALPosition, apply func(models.Event)) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.writer.Flush(); err != nil {
+		return 0, err
+	}
+	return w.replay(from, func(event models.Event) bool {
+		apply(event)
+		return true
+	})
+}
+
+// Every event in the log in dir, read without opening it for appending so a running server's log can be read
+func ReadWAL(dir string) iter.Seq2[models.Event, error] {
+	return func(yield func(models.Event, error) bool) {
+		w := &WAL{dir: dir}
+		if _, err := w.replay(WALPosition{}, func(event models.Event) bool { return yield(event, nil) }); err != nil {
+			yield(models.Event{}, err)
+		}
+	}
+}
+
+// Whether segments at the start of the log in dir have been deleted by Compact, so ReadWAL misses the
+// events before the last snapshot
+func WALCompacted(dir string) (bool, error) {
+	segments, err := (&WAL{dir: dir}).segments()
+	if err != nil {
+		return false, err
+	}
+	return len(segments) > 0 && segments[0] > 1, nil
+}
+
+// Pass events after the position to apply until it returns false
+func (w *WAL) replay(from WALPosition, apply func(models.Event) bool) (int, error) {
+	segments, err := w.segments()
+	if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	for _, segment := range segments {
+		if segment < from.Segment {
+			continue
+		}
+		var offset int64
+		if segment == from.Segment {
+			offset = from.Offset
+		}
+		count, stopped, err := w.replaySegment(segment, offset, apply)
+		replayed += count
+		if errors.Is(err, errCorruptRecord) || errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("Skipping the rest of %s: %v", w.segmentPath(segment), err)
+			continue
+		}
+		if err != nil || stopped {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+func (w *WAL) replaySegment(segment uint64, offset int64, apply func(models.Event) bool) (int, bool, error) {
+	file, err := os.Open(w.segmentPath(segment))
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+
+	reader := bufio.NewReader(file)
+	replayed := 0
+	for {
+		payload, err := readRecord(reader)
+		if errors.Is(err, io.EOF) {
+			return replayed, false, nil
+		}
+		if err != nil {
+			return replayed, false, err
+		}
+		var event models.Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return replayed, false, fmt.Errorf("%w: %w", errCorruptRecord, err)
+		}
+		replayed++
+		if !apply(event) {
+			return replayed, true, nil
+		}
+	}
+}
+
+// Delete the segments that end before the position, once a snapshot holds everything in them
+func (w *WAL) Compact(before WALPosition) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment >= before.Segment || segment >= w.position.Segment {
+			break
+		}
+		if err := os.Remove(w.segmentPath(segment)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read one record's payload, checking its CRC
+func readRecord(reader *bufio.Reader) ([]byte, error) {
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxWALRecord {
+		return nil, fmt.Errorf("%w: length %d", errCorruptRecord, size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errCorruptRecord)
+	}
+	return payload, nil
+}
+
+// Read past one valid record, returning its size
+func skipRecord(reader *bufio.Reader) (int64, error) {
+	payload, err := readRecord(reader)
+	if err != nil {
+		return 0, err
+	}
+	return int64(walHeaderSize + len(payload)), nil
+}
+
+// WALRecorder logs each event before passing it on to the database, and tracks how far the database has applied the log
+type WALRecorder struct {
+	Executer
+	wal     *WAL
+	lock    sync.Mutex
+	applied WALPosition
+}
+
+func NewWALRecorder(db Executer, wal *WAL) *WALRecorder {
+	return &WALRecorder{Executer: db, wal: wal, applied: wal.Position()}
+}
+
+func (r *WALRecorder) RecordEvent(event models.Event) {
+	position, err := r.wal.Append(event)
+	if err != nil {
+		log.Printf("Error appending to WAL: %v", err)
+	}
+	Record(r.Executer, event)
+	if err == nil {
+		r.lock.Lock()
+		r.applied = position
+		r.lock.Unlock()
+	}
+}
+
+// Position of the last logged event the database has applied
+func (r *WALRecorder) Applied() WALPosition {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.applied
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"wikistats/pkg/models"
+)
+
+func openTestWAL(t *testing.T, dir string, segmentSize int64) *WAL {
+	t.Helper()
+	wal, err := OpenWAL(dir, segmentSize, SyncAlways, 0)
+	if err != nil {
+		t.Fatalf("OpenWAL() error = %v", err)
+	}
+	return wal
+}
+
+func replayIDs(t *testing.T, wal *WAL, from WALPosition) []string {
+	t.Helper()
+	ids := make([]string, 0)
+	count, err := wal.Replay(from, func(event models.Event) {
+		ids = append(ids, event.ID)
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if count != len(ids) {
+		t.Errorf("replayed: got %d, want %d", count, len(ids))
+	}
+	return ids
+}
+
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	// Small segments so the events span several files
+	wal := openTestWAL(t, dir, 600)
+	positions := make([]WALPosition, 0)
+	for _, event := range snapshotEvents() {
+		position, err := wal.Append(event)
+		if err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+		positions = append(positions, position)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if segments := positions[len(positions)-1].Segment; segments < 2 {
+		t.Fatalf("segments: got %d, want rotation", segments)
+	}
+
+	wal = openTestWAL(t, dir, 600)
+	defer wal.Close()
+	if got := wal.Position(); got != positions[len(positions)-1] {
+		t.Errorf("position after reopening: got %+v, want %+v", got, positions[len(positions)-1])
+	}
+	tests := []struct {
+		name string
+		from WALPosition
+		want []string
+	}{
+		{name: "From the start", from: WALPosition{}, want: []string{"msg1", "msg2", "msg3", "msg4", "msg5", "msg6"}},
+		{name: "After a record", from: positions[1], want: []string{"msg3", "msg4", "msg5", "msg6"}},
+		{name: "After the last record", from: positions[5], want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replayIDs(t, wal, tt.from); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Events survive the round trip intact
+	var replayed []models.Event
+	if _, err := wal.Replay(WALPosition{}, func(event models.Event) { replayed = append(replayed, event) }); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	for i, event := range snapshotEvents() {
+		if !replayed[i].Time.Equal(event.Time) {
+			t.Errorf("event %d time: got %v, want %v", i, replayed[i].Time, event.Time)
+		}
+		replayed[i].Time = event.Time
+		if !reflect.DeepEqual(replayed[i], event) {
+			t.Errorf("event %d: got %+v, want %+v", i, replayed[i], event)
+		}
+	}
+
+	// Segments wholly before the position are removed
+	if compacted, err := WALCompacted(dir); err != nil || compacted {
+		t.Errorf("WALCompacted() before compaction = %v, %v", compacted, err)
+	}
+	if err := wal.Compact(positions[3]); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	segments, _ := wal.segments()
+	if segments[0] != positions[3].Segment {
+		t.Errorf("first segment after compaction: got %d, want %d", segments[0], positions[3].Segment)
+	}
+	if compacted, err := WALCompacted(dir); err != nil || !compacted {
+		t.Errorf("WALCompacted() after compaction = %v, %v", compacted, err)
+	}
+	if got := replayIDs(t, wal, positions[3]); !reflect.DeepEqual(got, []string{"msg5", "msg6"}) {
+		t.Errorf("after compaction: got %v", got)
+	}
+}
+
+func TestWALDamage(t *testing.T) {
+	events := snapshotEvents()
+	tests := []struct {
+		name string
+		// Change the single segment holding every event
+		damage func(data []byte) []byte
+		want   []string
+	}{
+		{
+			name:   "Torn tail",
+			damage: func(data []byte) []byte { return data[:len(data)-5] },
+			want:   []string{"msg1", "msg2", "msg3", "msg4", "msg5"},
+		},
+		{
+			name: "Checksum mismatch",
+			damage: func(data []byte) []byte {
+				data[walHeaderSize+10] ^= 0xff
+				return data
+			},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			wal := openTestWAL(t, dir, 0)
+			for _, event := range events {
+				if _, err := wal.Append(event); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+			}
+			wal.Close()
+			filename := filepath.Join(dir, "0000000000000001.wal")
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("Error reading segment: %v", err)
+			}
+			if err := os.WriteFile(filename, tt.damage(data), 0o644); err != nil {
+				t.Fatalf("Error writing segment: %v", err)
+			}
+
+			wal = openTestWAL(t, dir, 0)
+			defer wal.Close()
+			if got := replayIDs(t, wal, WALPosition{}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWALRecorder(t *testing.T) {
+	dir := t.TempDir()
+	wal := openTestWAL(t, dir, 0)
+	db := NewInMemoryDatabase()
+	recorder := NewWALRecorder(db, wal)
+	events := snapshotEvents()
+	for _, event := range events[:3] {
+		recorder.RecordEvent(event)
+	}
+	applied := recorder.Applied()
+	if applied != wal.Position() {
+		t.Errorf("applied: got %+v, want %+v", applied, wal.Position())
+	}
+	for _, event := range events[3:] {
+		recorder.RecordEvent(event)
+	}
+	wal.Close()
+
+	// A database restored to the earlier position catches up by replaying the rest
+	restored := NewInMemoryDatabase()
+	for _, event := range events[:3] {
+		restored.RecordEvent(event)
+	}
+	wal = openTestWAL(t, dir, 0)
+	defer wal.Close()
+	if _, err := wal.Replay(applied, restored.RecordEvent); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	want, got := databaseViews(db), databaseViews(restored)
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("view %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWALReplayToStore(t *testing.T) {
+	dir := t.TempDir()
+	wal := openTestWAL(t, dir, 0)
+	for _, event := range snapshotEvents() {
+		if _, err := wal.Append(event); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	wal.Close()
+
+	// Replayed events reach the storage backend as well as the aggregates in memory
+	store, err := OpenStore(BackendBolt, filepath.Join(t.TempDir(), "wikistats.db"))
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	defer store.Close()
+	db := NewStoredDatabase(store, NewInMemoryDatabase())
+	wal = openTestWAL(t, dir, 0)
+	defer wal.Close()
+	if _, err := wal.Replay(WALPosition{}, func(event models.Event) { Record(db, event) }); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	assertStats(t, store, wantState{messages: 6, users: 3, bots: 1, servers: 3})
+	if profile, ok := db.GetUserProfile("alice"); !ok || profile.Edits != 3 {
+		t.Errorf("alice: got %+v, want 3 edits", profile)
+	}
+}
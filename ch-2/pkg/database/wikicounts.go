@@ -0,0 +1,43 @@
+package database
+
+import "wikistats/pkg/models"
+
+// Running totals of events and edits on a wiki
+type WikiCounts struct {
+	Events     int `json:"events"`
+	Edits      int `json:"edits"`
+	HumanEdits int `json:"human_edits"`
+	BotEdits   int `json:"bot_edits"`
+}
+
+func (c *WikiCounts) record(event models.Event) {
+	c.Events++
+	if !event.IsEdit() {
+		return
+	}
+	c.Edits++
+	if event.Bot {
+		c.BotEdits++
+	} else {
+		c.HumanEdits++
+	}
+}
+
+// Add the counts of another wiki, for totals across wikis
+func (c *WikiCounts) Add(other WikiCounts) {
+	c.Events += other.Events
+	c.Edits += other.Edits
+	c.HumanEdits += other.HumanEdits
+	c.BotEdits += other.BotEdits
+}
+
+func (d *InMemoryDatabase) GetWikiCounts() map[string]WikiCounts {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	counts := make(map[string]WikiCounts, len(d.wikis))
+	for wiki, wikiCounts := range d.wikis {
+		counts[wiki] = *wikiCounts
+	}
+	return counts
+}
@@ -0,0 +1,269 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+	"wikistats/pkg/database"
+	"wikistats/pkg/models"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+type Format string
+
+const (
+	CSV     Format = "csv"
+	Parquet Format = "parquet"
+)
+
+// Rows written between flushes, so large exports are streamed rather than held in memory
+const flushRows = 10000
+
+// Datasets that can be exported
+const (
+	Events     = "events"
+	Wikis      = "wikis"
+	Namespaces = "namespaces"
+)
+
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(value); format {
+	case "":
+		return CSV, nil
+	case CSV, Parquet:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q, expected csv or parquet", value)
+	}
+}
+
+func (f Format) ContentType() string {
+	if f == Parquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv"
+}
+
+// A row that can also be written as CSV
+type row interface {
+	header() []string
+	record() []string
+}
+
+type EventRow struct {
+	ID          string    `parquet:"id"`
+	Time        time.Time `parquet:"time,timestamp(millisecond)"`
+	Wiki        string    `parquet:"wiki,dict"`
+	Server      string    `parquet:"server,dict"`
+	Type        string    `parquet:"type,dict"`
+	Namespace   int64     `parquet:"namespace"`
+	Title       string    `parquet:"title"`
+	User        string    `parquet:"user"`
+	Bot         bool      `parquet:"bot"`
+	Minor       bool      `parquet:"minor"`
+	Comment     string    `parquet:"comment"`
+	OldLength   int64     `parquet:"old_length"`
+	NewLength   int64     `parquet:"new_length"`
+	OldRevision int64     `parquet:"old_revision"`
+	NewRevision int64     `parquet:"new_revision"`
+	Country     string    `parquet:"country,dict"`
+}
+
+func newEventRow(event models.Event) EventRow {
+	return EventRow{
+		ID:          event.ID,
+		Time:        event.Time,
+		Wiki:        event.Wiki,
+		Server:      event.Server,
+		Type:        event.Type,
+		Namespace:   int64(event.Namespace),
+		Title:       event.Title,
+		User:        event.User,
+		Bot:         event.Bot,
+		Minor:       event.Minor,
+		Comment:     event.Comment,
+		OldLength:   int64(event.OldLength),
+		NewLength:   int64(event.NewLength),
+		OldRevision: int64(event.OldRevision),
+		NewRevision: int64(event.NewRevision),
+		Country:     event.Country,
+	}
+}
+
+func (EventRow) header() []string {
+	return []string{
+		"id", "time", "wiki", "server", "type", "namespace", "title", "user", "bot", "minor", "comment",
+		"old_length", "new_length", "old_revision", "new_revision", "country",
+	}
+}
+
+func (r EventRow) record() []string {
+	return []string{
+		r.ID, r.Time.Format(time.RFC3339), r.Wiki, r.Server, r.Type, strconv.FormatInt(r.Namespace, 10), r.Title, r.User,
+		strconv.FormatBool(r.Bot), strconv.FormatBool(r.Minor), r.Comment, strconv.FormatInt(r.OldLength, 10),
+		strconv.FormatInt(r.NewLength, 10), strconv.FormatInt(r.OldRevision, 10), strconv.FormatInt(r.NewRevision, 10), r.Country,
+	}
+}
+
+type WikiRow struct {
+	Wiki       string `parquet:"wiki"`
+	Events     int64  `parquet:"events"`
+	Edits      int64  `parquet:"edits"`
+	HumanEdits int64  `parquet:"human_edits"`
+	BotEdits   int64  `parquet:"bot_edits"`
+}
+
+func (WikiRow) header() []string {
+	return []string{"wiki", "events", "edits", "human_edits", "bot_edits"}
+}
+
+func (r WikiRow) record() []string {
+	return []string{r.Wiki, strconv.FormatInt(r.Events, 10), strconv.FormatInt(r.Edits, 10),
+		strconv.FormatInt(r.HumanEdits, 10), strconv.FormatInt(r.BotEdits, 10)}
+}
+
+type NamespaceRow struct {
+	Namespace  int64  `parquet:"namespace"`
+	Name       string `parquet:"name"`
+	Events     int64  `parquet:"events"`
+	Edits      int64  `parquet:"edits"`
+	MinorEdits int64  `parquet:"minor_edits"`
+}
+
+func (NamespaceRow) header() []string {
+	return []string{"namespace", "name", "events", "edits", "minor_edits"}
+}
+
+func (r NamespaceRow) record() []string {
+	return []string{strconv.FormatInt(r.Namespace, 10), r.Name, strconv.FormatInt(r.Events, 10),
+		strconv.FormatInt(r.Edits, 10), strconv.FormatInt(r.MinorEdits, 10)}
+}
+
+// Write the rows to w in the format, flushing every so often, and return how many were written
+func writeRows[T row](w io.Writer, format Format, rows iter.Seq[T]) (int, error) {
+	count := 0
+	switch format {
+	case Parquet:
+		writer := parquet.NewGenericWriter[T](w)
+		batch := make([]T, 0, flushRows)
+		for r := range rows {
+			batch = append(batch, r)
+			count++
+			if len(batch) < flushRows {
+				continue
+			}
+			// Each flush ends a row group, which is written out straight away
+			if _, err := writer.Write(batch); err != nil {
+				return count, err
+			}
+			if err := writer.Flush(); err != nil {
+				return count, err
+			}
+			batch = batch[:0]
+		}
+		if _, err := writer.Write(batch); err != nil {
+			return count, err
+		}
+		return count, writer.Close()
+	default:
+		writer := csv.NewWriter(w)
+		var zero T
+		if err := writer.Write(zero.header()); err != nil {
+			return 0, err
+		}
+		for r := range rows {
+			if err := writer.Write(r.record()); err != nil {
+				return count, err
+			}
+			count++
+			if count%flushRows == 0 {
+				writer.Flush()
+				if err := writer.Error(); err != nil {
+					return count, err
+				}
+			}
+		}
+		writer.Flush()
+		return count, writer.Error()
+	}
+}
+
+// Write the events as they're produced, stopping at the first error reading them
+func WriteEvents(w io.Writer, format Format, events iter.Seq2[models.Event, error]) (int, error) {
+	var readErr error
+	count, err := writeRows(w, format, func(yield func(EventRow) bool) {
+		for event, err := range events {
+			if err != nil {
+				readErr = err
+				return
+			}
+			if !yield(newEventRow(event)) {
+				return
+			}
+		}
+	})
+	if readErr != nil {
+		return count, readErr
+	}
+	return count, err
+}
+
+// Write each wiki's totals, in order of wiki
+func WriteWikis(w io.Writer, format Format, counts map[string]database.WikiCounts) (int, error) {
+	return writeRows(w, format, func(yield func(WikiRow) bool) {
+		for _, wiki := range slices.Sorted(maps.Keys(counts)) {
+			c := counts[wiki]
+			if !yield(WikiRow{Wiki: wiki, Events: int64(c.Events), Edits: int64(c.Edits), HumanEdits: int64(c.HumanEdits), BotEdits: int64(c.BotEdits)}) {
+				return
+			}
+		}
+	})
+}
+
+// Write the events and edits in each namespace
+func WriteNamespaces(w io.Writer, format Format, stats database.BreakdownStats) (int, error) {
+	return writeRows(w, format, func(yield func(NamespaceRow) bool) {
+		for _, namespace := range stats.Namespaces {
+			r := NamespaceRow{
+				Namespace:  int64(namespace.ID),
+				Name:       namespace.Name,
+				Events:     int64(namespace.Events),
+				Edits:      int64(namespace.Edits),
+				MinorEdits: int64(namespace.MinorEdits),
+			}
+			if !yield(r) {
+				return
+			}
+		}
+	})
+}
+
+// Every stored event matching the query's filters, fetched a page at a time
+func QueryAll(store database.EventStore, query database.EventQuery) iter.Seq2[models.Event, error] {
+	return func(yield func(models.Event, error) bool) {
+		query.Limit = database.MaxQueryLimit
+		query.Cursor = ""
+		for {
+			page, err := store.QueryEvents(query)
+			if err != nil {
+				yield(models.Event{}, err)
+				return
+			}
+			for _, event := range page.Events {
+				if !yield(event, nil) {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			query.Cursor = page.NextCursor
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"testing"
+	"time"
+	"wikistats/pkg/database"
+	"wikistats/pkg/models"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var start = time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+
+// Events produced one at a time, as a stream would
+func generate(count int) iter.Seq2[models.Event, error] {
+	return func(yield func(models.Event, error) bool) {
+		for i := range count {
+			event := models.Event{
+				ID:        fmt.Sprintf("msg%d", i),
+				Time:      start.Add(time.Duration(i) * time.Second),
+				Wiki:      "enwiki",
+				Type:      "edit",
+				Title:     "Go, the language",
+				User:      "alice",
+				NewLength: i,
+			}
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestWriteEventsCSV(t *testing.T) {
+	var buf bytes.Buffer
+	rows, err := WriteEvents(&buf, CSV, generate(2))
+	if err != nil {
+		t.Fatalf("WriteEvents() error = %v", err)
+	}
+	if rows != 2 {
+		t.Errorf("rows: got %d, want 2", rows)
+	}
+	want := `id,time,wiki,server,type,namespace,title,user,bot,minor,comment,old_length,new_length,old_revision,new_revision,country
+msg0,2025-02-02T00:00:00Z,enwiki,,edit,0,"Go, the language",alice,false,false,,0,0,0,0,
+msg1,2025-02-02T00:00:01Z,enwiki,,edit,0,"Go, the language",alice,false,false,,0,1,0,0,
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteEventsParquet(t *testing.T) {
+	// Enough events for several row groups
+	const count = 2*flushRows + 5
+	var buf bytes.Buffer
+	rows, err := WriteEvents(&buf, Parquet, generate(count))
+	if err != nil {
+		t.Fatalf("WriteEvents() error = %v", err)
+	}
+	if rows != count {
+		t.Errorf("rows: got %d, want %d", rows, count)
+	}
+
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	if groups := len(file.RowGroups()); groups != 3 {
+		t.Errorf("row groups: got %d, want 3", groups)
+	}
+	read, err := parquet.Read[EventRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(read) != count {
+		t.Fatalf("rows read: got %d, want %d", len(read), count)
+	}
+	last := read[count-1]
+	if last.ID != fmt.Sprintf("msg%d", count-1) || last.NewLength != count-1 || !last.Time.Equal(start.Add((count-1)*time.Second)) {
+		t.Errorf("last row: got %+v", last)
+	}
+}
+
+func TestWriteEventsError(t *testing.T) {
+	failing := func(yield func(models.Event, error) bool) {
+		for event := range generate(3) {
+			if !yield(event, nil) {
+				return
+			}
+		}
+		yield(models.Event{}, errors.New("stream broke"))
+	}
+	for _, format := range []Format{CSV, Parquet} {
+		rows, err := WriteEvents(&bytes.Buffer{}, format, failing)
+		if err == nil || rows != 3 {
+			t.Errorf("%s: got %d rows and %v, want 3 rows and an error", format, rows, err)
+		}
+	}
+}
+
+func TestWriteAggregates(t *testing.T) {
+	var buf bytes.Buffer
+	counts := map[string]database.WikiCounts{
+		"enwiki": {Events: 10, Edits: 8, HumanEdits: 6, BotEdits: 2},
+		"dewiki": {Events: 3, Edits: 1, HumanEdits: 1},
+	}
+	if _, err := WriteWikis(&buf, CSV, counts); err != nil {
+		t.Fatalf("WriteWikis() error = %v", err)
+	}
+	want := "wiki,events,edits,human_edits,bot_edits\ndewiki,3,1,1,0\nenwiki,10,8,6,2\n"
+	if got := buf.String(); got != want {
+		t.Errorf("wikis: got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	stats := database.BreakdownStats{Namespaces: []database.NamespaceStats{{ID: 0, Name: "Main", Events: 5, Edits: 4, MinorEdits: 1}}}
+	if _, err := WriteNamespaces(&buf, Parquet, stats); err != nil {
+		t.Fatalf("WriteNamespaces() error = %v", err)
+	}
+	read, err := parquet.Read[NamespaceRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(read) != 1 || read[0] != (NamespaceRow{Name: "Main", Events: 5, Edits: 4, MinorEdits: 1}) {
+		t.Errorf("namespaces: got %+v", read)
+	}
+}
+
+func TestQueryAll(t *testing.T) {
+	store := database.NewInMemoryEventStore(5000)
+	for event := range generate(2500) {
+		if strings.HasSuffix(event.ID, "7") {
+			event.Wiki = "dewiki"
+		}
+		store.StoreEvent(event)
+	}
+	// Every page of matching events, ignoring the requested page
+	query := database.EventQuery{Wikis: []string{"enwiki"}, Since: start.Add(100 * time.Second), Limit: 10, Cursor: "ignored"}
+	count := 0
+	for event, err := range QueryAll(store, query) {
+		if err != nil {
+			t.Fatalf("QueryAll() error = %v", err)
+		}
+		if event.Wiki != "enwiki" {
+			t.Fatalf("Unexpected event %+v", event)
+		}
+		count++
+	}
+	if count != 2400*9/10 {
+		t.Errorf("events: got %d, want %d", count, 2400*9/10)
+	}
+}
@@ -0,0 +1,69 @@
+package firehose
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"wikistats/pkg/models"
+)
+
+// Criteria an event must meet to be sent to a subscriber, unset fields match everything
+type Filter struct {
+	Wikis     []string
+	Namespace *int
+	Users     []string
+	Title     *regexp.Regexp
+	Bot       *bool
+}
+
+// Build a filter from query parameters of the form ?wiki=enwiki&namespace=0&user=alice&title=^Talk:&bot=false
+// The wiki and user parameters may be repeated to match any of several values
+func ParseFilter(query url.Values) (Filter, error) {
+	filter := Filter{
+		Wikis: query["wiki"],
+		Users: query["user"],
+	}
+	if value := query.Get("namespace"); value != "" {
+		namespace, err := strconv.Atoi(value)
+		if err != nil {
+			return Filter{}, fmt.Errorf("parsing namespace %q: %w", value, err)
+		}
+		filter.Namespace = &namespace
+	}
+	if value := query.Get("title"); value != "" {
+		title, err := regexp.Compile(value)
+		if err != nil {
+			return Filter{}, fmt.Errorf("parsing title pattern %q: %w", value, err)
+		}
+		filter.Title = title
+	}
+	if value := query.Get("bot"); value != "" {
+		bot, err := strconv.ParseBool(value)
+		if err != nil {
+			return Filter{}, fmt.Errorf("parsing bot %q: %w", value, err)
+		}
+		filter.Bot = &bot
+	}
+	return filter, nil
+}
+
+func (f Filter) Match(event models.Event) bool {
+	if len(f.Wikis) > 0 && !slices.Contains(f.Wikis, event.Wiki) {
+		return false
+	}
+	if f.Namespace != nil && *f.Namespace != event.Namespace {
+		return false
+	}
+	if len(f.Users) > 0 && !slices.Contains(f.Users, event.User) {
+		return false
+	}
+	if f.Title != nil && !f.Title.MatchString(event.Title) {
+		return false
+	}
+	if f.Bot != nil && *f.Bot != event.Bot {
+		return false
+	}
+	return true
+}
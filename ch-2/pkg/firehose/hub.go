@@ -0,0 +1,222 @@
+package firehose
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wikistats/pkg/models"
+
+	"golang.org/x/net/websocket"
+)
+
+// Maximum time to wait for a client to accept a single event
+const writeTimeout = 10 * time.Second
+
+// Hub fans consumed events out to WebSocket subscribers whose filters match
+type Hub struct {
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+	bufferSize  int
+	closed      bool
+	dropped     atomic.Int64
+	// Origins besides the hub's own that browsers may connect from, such as https://dashboard.example.com
+	origins []string
+}
+
+type subscriber struct {
+	filter    Filter
+	events    chan models.Event
+	remote    string
+	connected time.Time
+	sent      atomic.Int64
+	dropped   atomic.Int64
+}
+
+// Delivery counters for a single subscriber
+type ClientStats struct {
+	Remote    string    `json:"remote"`
+	Connected time.Time `json:"connected"`
+	Sent      int64     `json:"sent"`
+	Dropped   int64     `json:"dropped"`
+	Buffered  int       `json:"buffered"`
+}
+
+// Delivery counters for the hub and all connected subscribers
+type Stats struct {
+	Clients []ClientStats `json:"clients"`
+	Dropped int64         `json:"dropped"`
+}
+
+// Create a hub where each subscriber can have up to bufferSize events waiting to be sent
+func NewHub(bufferSize int) *Hub {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	return &Hub{
+		subscribers: make(map[*subscriber]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+// Let pages served from the origins open the firehose as well as pages from the same host
+func (h *Hub) WithAllowedOrigins(origins []string) *Hub {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, origin := range origins {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			h.origins = append(h.origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	return h
+}
+
+// Reject connections from browsers on pages of other sites, so they can't read the firehose
+// cross-origin. Clients other than browsers don't send an Origin and are let through
+func (h *Hub) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("invalid origin %q: %w", origin, err)
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if !slices.ContainsFunc(h.origins, func(allowed string) bool { return strings.EqualFold(allowed, origin) }) {
+		return fmt.Errorf("origin %s not allowed", origin)
+	}
+	return nil
+}
+
+// Queue the event for every matching subscriber, dropping it for any whose buffer is full
+func (h *Hub) HandleEvent(event models.Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for sub := range h.subscribers {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+			h.dropped.Add(1)
+		}
+	}
+}
+
+func (h *Hub) subscribe(filter Filter, remote string) *subscriber {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	sub := &subscriber{
+		filter:    filter,
+		events:    make(chan models.Event, h.bufferSize),
+		remote:    remote,
+		connected: time.Now().UTC(),
+	}
+	if h.closed {
+		close(sub.events)
+		return sub
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (h *Hub) unsubscribe(sub *subscriber) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Disconnect all subscribers and reject new ones
+func (h *Hub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func (h *Hub) Stats() Stats {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	stats := Stats{
+		Clients: make([]ClientStats, 0, len(h.subscribers)),
+		Dropped: h.dropped.Load(),
+	}
+	for sub := range h.subscribers {
+		stats.Clients = append(stats.Clients, ClientStats{
+			Remote:    sub.remote,
+			Connected: sub.connected,
+			Sent:      sub.sent.Load(),
+			Dropped:   sub.dropped.Load(),
+			Buffered:  len(sub.events),
+		})
+	}
+	return stats
+}
+
+// Upgrade the request to a WebSocket that streams events matching the query parameter filter
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filter, err := ParseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	server := websocket.Server{
+		// Responds with 403 Forbidden when the origin isn't allowed
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			return h.checkOrigin(r)
+		},
+		Handler: func(ws *websocket.Conn) {
+			h.stream(ws, filter, r.RemoteAddr)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+func (h *Hub) stream(ws *websocket.Conn, filter Filter, remote string) {
+	defer ws.Close()
+	sub := h.subscribe(filter, remote)
+	defer h.unsubscribe(sub)
+
+	// Clear the deadlines inherited from the HTTP server so the connection can stay open
+	ws.SetDeadline(time.Time{})
+	// Clients don't send anything, so reading only serves to detect disconnects
+	go func() {
+		io.Copy(io.Discard, ws)
+		h.unsubscribe(sub)
+	}()
+	for event := range sub.events {
+		ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := websocket.JSON.Send(ws, event); err != nil {
+			log.Printf("Error sending event to %s: %v", remote, err)
+			return
+		}
+		sub.sent.Add(1)
+	}
+}
@@ -0,0 +1,169 @@
+package firehose
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"wikistats/pkg/models"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestParseFilter(t *testing.T) {
+	event := models.Event{Wiki: "enwiki", Namespace: 0, User: "alice", Title: "Go (programming language)", Bot: false}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+		want    bool
+	}{
+		{name: "Empty filter matches everything", query: "", want: true},
+		{name: "Matching wiki", query: "wiki=dewiki&wiki=enwiki", want: true},
+		{name: "Other wiki", query: "wiki=dewiki", want: false},
+		{name: "Matching namespace", query: "namespace=0", want: true},
+		{name: "Other namespace", query: "namespace=1", want: false},
+		{name: "Matching user", query: "user=alice", want: true},
+		{name: "Other user", query: "user=bob", want: false},
+		{name: "Matching title pattern", query: "title=" + url.QueryEscape("^Go \\("), want: true},
+		{name: "Other title pattern", query: "title=^Talk:", want: false},
+		{name: "Matching bot flag", query: "bot=false", want: true},
+		{name: "Other bot flag", query: "bot=true", want: false},
+		{name: "Invalid namespace", query: "namespace=main", wantErr: true},
+		{name: "Invalid title pattern", query: "title=(", wantErr: true},
+		{name: "Invalid bot flag", query: "bot=maybe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Invalid test query: %v", err)
+			}
+			filter, err := ParseFilter(query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := filter.Match(event); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleEventDropsWhenBufferFull(t *testing.T) {
+	hub := NewHub(2)
+	sub := hub.subscribe(Filter{}, "test")
+	for i := 0; i < 5; i++ {
+		hub.HandleEvent(models.Event{ID: "msg"})
+	}
+	stats := hub.Stats()
+	if len(stats.Clients) != 1 {
+		t.Fatalf("clients: got %d, want 1", len(stats.Clients))
+	}
+	if stats.Clients[0].Buffered != 2 {
+		t.Errorf("buffered: got %d, want 2", stats.Clients[0].Buffered)
+	}
+	if stats.Clients[0].Dropped != 3 || stats.Dropped != 3 {
+		t.Errorf("dropped: got client %d hub %d, want 3", stats.Clients[0].Dropped, stats.Dropped)
+	}
+	hub.unsubscribe(sub)
+	if len(hub.Stats().Clients) != 0 {
+		t.Errorf("Subscriber not removed")
+	}
+}
+
+func TestStream(t *testing.T) {
+	hub := NewHub(16)
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?wiki=enwiki&bot=false"
+	ws, err := websocket.Dial(wsURL, "", server.URL)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer ws.Close()
+
+	// Wait for the subscription to be registered before publishing
+	deadline := time.Now().Add(time.Second)
+	for len(hub.Stats().Clients) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Subscriber never registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	hub.HandleEvent(models.Event{ID: "1", Wiki: "dewiki"})
+	hub.HandleEvent(models.Event{ID: "2", Wiki: "enwiki", Bot: true})
+	hub.HandleEvent(models.Event{ID: "3", Wiki: "enwiki"})
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var got models.Event
+	if err := websocket.JSON.Receive(ws, &got); err != nil {
+		t.Fatalf("Error receiving event: %v", err)
+	}
+	if got.ID != "3" {
+		t.Errorf("Received event %s, want 3", got.ID)
+	}
+
+	hub.Close()
+	if err := websocket.JSON.Receive(ws, &got); err == nil {
+		t.Errorf("Connection still open after hub closed, received %s", got.ID)
+	}
+}
+
+func TestServeHTTPRejectsInvalidFilter(t *testing.T) {
+	hub := NewHub(1)
+	recorder := httptest.NewRecorder()
+	hub.ServeHTTP(recorder, httptest.NewRequest("GET", "/firehose?namespace=main", nil))
+	if recorder.Code != 400 {
+		t.Errorf("status: got %d, want 400", recorder.Code)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	hub := NewHub(1).WithAllowedOrigins([]string{"https://dashboard.example.com/", " "})
+	tests := []struct {
+		name    string
+		origin  string
+		wantErr bool
+	}{
+		{name: "No origin from clients other than browsers", origin: ""},
+		{name: "Same host", origin: "http://wikistats.example.com:7000"},
+		{name: "Allowed origin", origin: "https://dashboard.example.com"},
+		{name: "Other site", origin: "https://evil.example.net", wantErr: true},
+		{name: "Allowed host with another scheme", origin: "http://dashboard.example.com", wantErr: true},
+		{name: "Opaque origin", origin: "null", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://wikistats.example.com:7000/firehose", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if err := hub.checkOrigin(r); (err != nil) != tt.wantErr {
+				t.Errorf("checkOrigin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamRejectsOtherOrigins(t *testing.T) {
+	hub := NewHub(1)
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	if ws, err := websocket.Dial(wsURL, "", "https://evil.example.net"); err == nil {
+		ws.Close()
+		t.Error("Connected from another origin")
+	}
+	if clients := len(hub.Stats().Clients); clients != 0 {
+		t.Errorf("clients: got %d, want 0", clients)
+	}
+}
@@ -0,0 +1,154 @@
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"wikistats/pkg/models"
+)
+
+// Database maps IP addresses to ISO 3166 country codes from a local file
+type Database interface {
+	Country(addr netip.Addr) (string, bool)
+}
+
+// Open a MaxMind DB (.mmdb) or CSV range file, choosing the format from the extension
+func Open(filename string) (Database, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".mmdb") {
+		return openMMDB(filename)
+	}
+	return openCSV(filename)
+}
+
+type ipRange struct {
+	start   netip.Addr
+	end     netip.Addr
+	country string
+}
+
+// Sorted, non-overlapping address ranges
+type csvDatabase struct {
+	ranges []ipRange
+}
+
+// Load rows of the form start,end,country or network,country, such as 192.0.2.0,192.0.2.255,CA
+// or 2001:db8::/32,DE. Blank lines, lines starting with # and a header row are skipped
+func openCSV(filename string) (*csvDatabase, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	db := &csvDatabase{}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", filename, err)
+		}
+		r, err := parseRange(record)
+		if err != nil {
+			if row == 1 {
+				continue
+			}
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("%s line %d: %w", filename, line, err)
+		}
+		db.ranges = append(db.ranges, r)
+	}
+	slices.SortFunc(db.ranges, func(a, b ipRange) int {
+		return a.start.Compare(b.start)
+	})
+	for i := 1; i < len(db.ranges); i++ {
+		if db.ranges[i].start.Compare(db.ranges[i-1].end) <= 0 {
+			return nil, fmt.Errorf("%s: range starting %s overlaps the previous range", filename, db.ranges[i].start)
+		}
+	}
+	return db, nil
+}
+
+func parseRange(record []string) (ipRange, error) {
+	switch len(record) {
+	case 2:
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(record[0]))
+		if err != nil {
+			return ipRange{}, err
+		}
+		prefix = prefix.Masked()
+		return ipRange{start: prefix.Addr(), end: lastAddr(prefix), country: strings.TrimSpace(record[1])}, nil
+	case 3:
+		start, err := netip.ParseAddr(strings.TrimSpace(record[0]))
+		if err != nil {
+			return ipRange{}, err
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err != nil {
+			return ipRange{}, err
+		}
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return ipRange{}, fmt.Errorf("invalid range %s to %s", start, end)
+		}
+		return ipRange{start: start, end: end, country: strings.TrimSpace(record[2])}, nil
+	}
+	return ipRange{}, fmt.Errorf("expected 2 or 3 fields, got %d", len(record))
+}
+
+// Highest address in the prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+func (db *csvDatabase) Country(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+	// Find the last range starting at or before the address
+	i, found := slices.BinarySearchFunc(db.ranges, addr, func(r ipRange, target netip.Addr) int {
+		return r.start.Compare(target)
+	})
+	if !found {
+		i--
+	}
+	if i < 0 || db.ranges[i].end.Less(addr) || db.ranges[i].country == "" {
+		return "", false
+	}
+	return db.ranges[i].country, true
+}
+
+// Enricher sets the country of anonymous edits from a GeoIP database
+type Enricher struct {
+	db Database
+}
+
+func NewEnricher(db Database) *Enricher {
+	return &Enricher{db: db}
+}
+
+func (e *Enricher) Enrich(event *models.Event) {
+	if models.ClassifyEditor(event.User) != models.Anonymous {
+		return
+	}
+	addr, err := netip.ParseAddr(event.User)
+	if err != nil {
+		return
+	}
+	if country, ok := e.db.Country(addr); ok {
+		event.Country = country
+	}
+}
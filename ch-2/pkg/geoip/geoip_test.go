@@ -0,0 +1,169 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"wikistats/pkg/models"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+type testNetwork struct {
+	prefix  string
+	country string
+}
+
+var testNetworks = []testNetwork{
+	{prefix: "192.0.2.0/24", country: "CA"},
+	{prefix: "198.51.100.0/25", country: "DE"},
+	{prefix: "2001:db8::/32", country: "FR"},
+}
+
+var lookups = []struct {
+	addr    string
+	want    string
+	wantHit bool
+}{
+	{addr: "192.0.2.1", want: "CA", wantHit: true},
+	{addr: "192.0.2.255", want: "CA", wantHit: true},
+	{addr: "::ffff:192.0.2.7", want: "CA", wantHit: true},
+	{addr: "198.51.100.127", want: "DE", wantHit: true},
+	{addr: "198.51.100.128", wantHit: false},
+	{addr: "203.0.113.1", wantHit: false},
+	{addr: "2001:db8:1234::1", want: "FR", wantHit: true},
+	{addr: "2001:db9::1", wantHit: false},
+}
+
+func TestMMDB(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		t.Run(fmt.Sprintf("Record size %d", recordSize), func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "country.mmdb")
+			writeMMDB(t, filename, recordSize, testNetworks)
+			db, err := Open(filename)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			assertLookups(t, db)
+		})
+	}
+}
+
+func TestMMDBInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(filename, []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("Error writing database: %v", err)
+	}
+	if _, err := Open(filename); err == nil {
+		t.Error("Expected error for file without metadata")
+	}
+}
+
+func TestCSV(t *testing.T) {
+	contents := `network,country
+# Ranges can be given as CIDR networks or start and end addresses
+192.0.2.0/24,CA
+198.51.100.0, 198.51.100.127, DE
+
+2001:db8::/32,FR
+`
+	filename := filepath.Join(t.TempDir(), "country.csv")
+	if err := os.WriteFile(filename, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Error writing database: %v", err)
+	}
+	db, err := Open(filename)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	assertLookups(t, db)
+}
+
+func TestCSVInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{name: "Invalid address", contents: "192.0.2.0/24,CA\nnot-an-ip,DE\n"},
+		{name: "Reversed range", contents: "192.0.2.0/24,CA\n198.51.100.200,198.51.100.1,DE\n"},
+		{name: "Overlapping ranges", contents: "192.0.2.0/24,CA\n192.0.2.128/25,DE\n"},
+		{name: "Too many fields", contents: "192.0.2.0/24,CA\n198.51.100.0,198.51.100.1,DE,extra\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "country.csv")
+			if err := os.WriteFile(filename, []byte(tt.contents), 0o600); err != nil {
+				t.Fatalf("Error writing database: %v", err)
+			}
+			if _, err := Open(filename); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	db := &csvDatabase{ranges: []ipRange{{
+		start:   netip.MustParseAddr("192.0.2.0"),
+		end:     netip.MustParseAddr("192.0.2.255"),
+		country: "CA",
+	}}}
+	enricher := NewEnricher(db)
+	tests := []struct {
+		user string
+		want string
+	}{
+		{user: "192.0.2.1", want: "CA"},
+		{user: "203.0.113.1", want: ""},
+		{user: "alice", want: ""},
+		{user: "~2025-12345-67", want: ""},
+	}
+	for _, tt := range tests {
+		event := models.Event{User: tt.user}
+		enricher.Enrich(&event)
+		if event.Country != tt.want {
+			t.Errorf("%s: got country %q, want %q", tt.user, event.Country, tt.want)
+		}
+	}
+}
+
+func assertLookups(t *testing.T, db Database) {
+	t.Helper()
+	for _, lookup := range lookups {
+		got, ok := db.Country(netip.MustParseAddr(lookup.addr))
+		if ok != lookup.wantHit || got != lookup.want {
+			t.Errorf("%s: got %q %v, want %q %v", lookup.addr, got, ok, lookup.want, lookup.wantHit)
+		}
+	}
+}
+
+// Write an IPv6 MaxMind DB holding {"country": {"iso_code": ...}} records for the networks
+func writeMMDB(t *testing.T, filename string, recordSize int, networks []testNetwork) {
+	t.Helper()
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "Test-Country", RecordSize: recordSize, IncludeReservedNetworks: true})
+	if err != nil {
+		t.Fatalf("mmdbwriter.New() error = %v", err)
+	}
+	for _, network := range networks {
+		_, prefix, err := net.ParseCIDR(network.prefix)
+		if err != nil {
+			t.Fatalf("Invalid network %s: %v", network.prefix, err)
+		}
+		record := mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String(network.country)}}
+		if err := tree.Insert(prefix, record); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Error creating database: %v", err)
+	}
+	defer file.Close()
+	if _, err := tree.WriteTo(file); err != nil {
+		t.Fatalf("Error writing database: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package geoip
+
+import (
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// MaxMind DB file, such as a GeoLite2 or GeoIP2 country or city database
+type mmdbDatabase struct {
+	reader *maxminddb.Reader
+}
+
+// The parts of a country or city record holding country codes
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+func openMMDB(filename string) (*mmdbDatabase, error) {
+	reader, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &mmdbDatabase{reader: reader}, nil
+}
+
+// ISO 3166 country code for the address from the country, or failing that registered_country, record
+func (db *mmdbDatabase) Country(addr netip.Addr) (string, bool) {
+	var record mmdbRecord
+	if err := db.reader.Lookup(addr.Unmap()).Decode(&record); err != nil {
+		return "", false
+	}
+	for _, code := range []string{record.Country.ISOCode, record.RegisteredCountry.ISOCode} {
+		if code != "" {
+			return code, true
+		}
+	}
+	return "", false
+}
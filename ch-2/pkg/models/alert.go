@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type AlertState string
+
+const (
+	Firing   AlertState = "firing"
+	Resolved AlertState = "resolved"
+)
+
+// A change in an alert's state, as delivered to notifiers
+type Alert struct {
+	// Identifies what raised the alert, such as anomaly/enwiki, and is the key alerts are rate limited by
+	Rule    string     `json:"rule"`
+	Source  string     `json:"source"`
+	State   AlertState `json:"state"`
+	Time    time.Time  `json:"time"`
+	Summary string     `json:"summary"`
+	Details any        `json:"details,omitempty"`
+}
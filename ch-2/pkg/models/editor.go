@@ -0,0 +1,26 @@
+package models
+
+import (
+	"net/netip"
+	"strings"
+)
+
+type EditorType string
+
+const (
+	Registered EditorType = "registered"
+	Anonymous  EditorType = "anonymous"
+	Temporary  EditorType = "temporary"
+)
+
+// Classify a username, where anonymous edits are attributed to an IP address and temporary
+// accounts, which replace IP editing on wikis that enable them, are named like ~2025-12345-67
+func ClassifyEditor(user string) EditorType {
+	if strings.HasPrefix(user, "~") {
+		return Temporary
+	}
+	if _, err := netip.ParseAddr(user); err == nil {
+		return Anonymous
+	}
+	return Registered
+}
@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// Normalized form of a Message with the fields used for filtering and aggregation
+type Event struct {
+	ID          string    `json:"id"`
+	Time        time.Time `json:"time"`
+	Wiki        string    `json:"wiki"`
+	Server      string    `json:"server"`
+	Type        string    `json:"type"`
+	Namespace   int       `json:"namespace"`
+	Title       string    `json:"title"`
+	User        string    `json:"user"`
+	Bot         bool      `json:"bot"`
+	Minor       bool      `json:"minor"`
+	Comment     string    `json:"comment"`
+	OldLength   int       `json:"old_length"`
+	NewLength   int       `json:"new_length"`
+	OldRevision int       `json:"old_revision"`
+	NewRevision int       `json:"new_revision"`
+	Country     string    `json:"country,omitempty"`
+}
+
+// Build an Event from a raw stream message, flattening the optional fields
+func NewEvent(msg Message) Event {
+	event := Event{
+		ID:        msg.Meta.ID,
+		Wiki:      msg.Wiki,
+		Server:    msg.ServerURL,
+		Type:      msg.Type,
+		Namespace: msg.Namespace,
+		Title:     msg.Title,
+		User:      msg.User,
+		Bot:       msg.Bot,
+		Comment:   msg.Comment,
+	}
+	// Prefer the event metadata time, falling back to the change timestamp
+	if t, err := time.Parse(time.RFC3339, msg.Meta.DT); err == nil {
+		event.Time = t.UTC()
+	} else if msg.Timestamp != 0 {
+		event.Time = time.Unix(msg.Timestamp, 0).UTC()
+	}
+	if msg.Minor != nil {
+		event.Minor = *msg.Minor
+	}
+	if msg.Length != nil {
+		event.OldLength = msg.Length.Old
+		event.NewLength = msg.Length.New
+	}
+	if msg.Revision != nil {
+		event.OldRevision = msg.Revision.Old
+		event.NewRevision = msg.Revision.New
+	}
+	return event
+}
+
+// Whether the event changed page content, as opposed to log or categorization events
+func (e Event) IsEdit() bool {
+	return e.Type == "edit" || e.Type == "new"
+}
+
+// Change in page size caused by the event in bytes
+func (e Event) ByteDelta() int {
+	return e.NewLength - e.OldLength
+}
@@ -0,0 +1,41 @@
+package models
+
+import "fmt"
+
+// Canonical names of the namespaces shared by most Wikimedia wikis
+var namespaceNames = map[int]string{
+	-2:  "Media",
+	-1:  "Special",
+	0:   "Main",
+	1:   "Talk",
+	2:   "User",
+	3:   "User talk",
+	4:   "Project",
+	5:   "Project talk",
+	6:   "File",
+	7:   "File talk",
+	8:   "MediaWiki",
+	9:   "MediaWiki talk",
+	10:  "Template",
+	11:  "Template talk",
+	12:  "Help",
+	13:  "Help talk",
+	14:  "Category",
+	15:  "Category talk",
+	100: "Portal",
+	101: "Portal talk",
+	118: "Draft",
+	119: "Draft talk",
+	710: "TimedText",
+	711: "TimedText talk",
+	828: "Module",
+	829: "Module talk",
+}
+
+// Human readable name of a namespace ID, falling back to the number for wiki specific namespaces
+func NamespaceName(id int) string {
+	if name, ok := namespaceNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("Namespace %d", id)
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"sync"
+	"time"
+)
+
+// Token bucket per rule
+type rateLimiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	burst    int
+	buckets  map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{
+		interval: interval,
+		burst:    burst,
+		buckets:  make(map[string]*bucket),
+	}
+}
+
+// Take a token from the rule's bucket if one is available
+func (l *rateLimiter) allow(rule string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	b, ok := l.buckets[rule]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[rule] = b
+	}
+	b.tokens = min(float64(l.burst), b.tokens+float64(now.Sub(b.last))/float64(l.interval))
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	// Full buckets are the same as missing ones, so forget them to bound memory
+	if len(l.buckets) > 1000 {
+		for rule, b := range l.buckets {
+			if float64(l.burst)-b.tokens <= float64(now.Sub(b.last))/float64(l.interval) {
+				delete(l.buckets, rule)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,251 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"wikistats/pkg/models"
+)
+
+const (
+	DefaultAttempts = 5
+	DefaultBackoff  = time.Second
+	// Alerts waiting for delivery before new ones are dropped
+	queueSize = 256
+	// Header carrying the hex HMAC-SHA256 of the request body, prefixed with sha256=
+	SignatureHeader = "X-Wikistats-Signature"
+)
+
+// WebhookNotifier POSTs alerts as JSON to each configured URL, retrying failed deliveries with
+// exponential backoff and appending alerts that could not be delivered to a dead-letter file
+type WebhookNotifier struct {
+	urls       []string
+	secret     []byte
+	client     *http.Client
+	queue      chan models.Alert
+	attempts   int
+	backoff    time.Duration
+	deadLetter string
+	limiter    *rateLimiter
+	lock       sync.Mutex
+	stats      Stats
+	// Rules whose firing alert got past the rate limit, so their resolution must be sent too
+	firing map[string]bool
+}
+
+// Delivery counters since startup
+type Stats struct {
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+	// Attempts made after the first for a delivery
+	Retried int `json:"retried"`
+	// Failed deliveries appended to the dead-letter file
+	DeadLettered int `json:"dead_lettered"`
+	// Alerts dropped because the queue was full
+	Dropped     int `json:"dropped"`
+	RateLimited int `json:"rate_limited"`
+}
+
+// Create a notifier signing payloads with the secret when it is not empty
+func NewWebhookNotifier(urls []string, secret string) *WebhookNotifier {
+	return &WebhookNotifier{
+		urls:     urls,
+		secret:   []byte(secret),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan models.Alert, queueSize),
+		attempts: DefaultAttempts,
+		backoff:  DefaultBackoff,
+		firing:   make(map[string]bool),
+	}
+}
+
+// Set how many times each delivery is attempted and the delay before the first retry, which doubles after each one
+func (n *WebhookNotifier) WithRetries(attempts int, backoff time.Duration) *WebhookNotifier {
+	if attempts > 0 {
+		n.attempts = attempts
+	}
+	if backoff > 0 {
+		n.backoff = backoff
+	}
+	return n
+}
+
+// Append alerts that could not be delivered to the file as JSON lines
+func (n *WebhookNotifier) WithDeadLetter(filename string) *WebhookNotifier {
+	n.deadLetter = filename
+	return n
+}
+
+// Allow each rule a burst of alerts, refilled at one alert per interval
+func (n *WebhookNotifier) WithRateLimit(interval time.Duration, burst int) *WebhookNotifier {
+	if interval > 0 && burst > 0 {
+		n.limiter = newRateLimiter(interval, burst)
+	}
+	return n
+}
+
+// Queue the alert for delivery without blocking, dropping it if its rule is over the rate limit or the queue is full
+func (n *WebhookNotifier) Notify(alert models.Alert) {
+	if !n.allow(alert) {
+		n.count(func(stats *Stats) { stats.RateLimited++ })
+		return
+	}
+	select {
+	case n.queue <- alert:
+	default:
+		log.Printf("Webhook queue full, dropping alert %s", alert.Rule)
+		n.count(func(stats *Stats) { stats.Dropped++ })
+	}
+}
+
+// Deliver queued alerts until the context is canceled
+func (n *WebhookNotifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alert := <-n.queue:
+			n.deliver(ctx, alert)
+		}
+	}
+}
+
+// Firing alerts are rate limited by rule. A resolution is always sent when its firing alert was, so
+// receivers aren't left thinking the rule is still firing, and is dropped along with it otherwise
+func (n *WebhookNotifier) allow(alert models.Alert) bool {
+	if n.limiter == nil {
+		return true
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if alert.State == models.Resolved {
+		sent := n.firing[alert.Rule]
+		delete(n.firing, alert.Rule)
+		return sent
+	}
+	if !n.limiter.allow(alert.Rule, time.Now()) {
+		return false
+	}
+	n.firing[alert.Rule] = true
+	return true
+}
+
+func (n *WebhookNotifier) Stats() Stats {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.stats
+}
+
+func (n *WebhookNotifier) count(update func(*Stats)) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	update(&n.stats)
+}
+
+func (n *WebhookNotifier) deliver(ctx context.Context, alert models.Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Error encoding alert %s: %v", alert.Rule, err)
+		return
+	}
+	for _, url := range n.urls {
+		err := n.post(ctx, url, body)
+		if err == nil {
+			n.count(func(stats *Stats) { stats.Delivered++ })
+			continue
+		}
+		log.Printf("Error delivering alert %s to %s: %v", alert.Rule, url, err)
+		n.count(func(stats *Stats) { stats.Failed++ })
+		if n.deadLetter == "" {
+			continue
+		}
+		if err := n.writeDeadLetter(url, alert, err); err != nil {
+			log.Printf("Error writing dead letter: %v", err)
+			continue
+		}
+		n.count(func(stats *Stats) { stats.DeadLettered++ })
+	}
+}
+
+// POST the body, retrying network errors, rate limiting and server errors with exponential backoff
+func (n *WebhookNotifier) post(ctx context.Context, url string, body []byte) error {
+	delay := n.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		if retry, err = n.attempt(ctx, url, body); err == nil || !retry || attempt == n.attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, last error: %w", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		n.count(func(stats *Stats) { stats.Retried++ })
+		delay *= 2
+	}
+}
+
+// Make one delivery attempt, returning whether a failure is worth retrying
+func (n *WebhookNotifier) attempt(ctx context.Context, url string, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		request.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+	response, err := n.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status %s", response.Status)
+}
+
+// Signature of the body in the form sent in the signature header
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type deadLetter struct {
+	URL   string       `json:"url"`
+	Error string       `json:"error"`
+	Time  time.Time    `json:"time"`
+	Alert models.Alert `json:"alert"`
+}
+
+func (n *WebhookNotifier) writeDeadLetter(url string, alert models.Alert, deliveryErr error) error {
+	line, err := json.Marshal(deadLetter{URL: url, Error: deliveryErr.Error(), Time: time.Now(), Alert: alert})
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(n.deadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,197 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"wikistats/pkg/models"
+)
+
+// Receiver answering with the given status codes in turn, then 200
+type receiver struct {
+	lock     sync.Mutex
+	statuses []int
+	requests int
+	alerts   []models.Alert
+	signed   bool
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	r.requests++
+	r.signed = req.Header.Get(SignatureHeader) == Sign([]byte("secret"), body)
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	var alert models.Alert
+	json.Unmarshal(body, &alert)
+	r.alerts = append(r.alerts, alert)
+}
+
+func (r *receiver) snapshot() (int, []models.Alert, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.requests, r.alerts, r.signed
+}
+
+// Run the notifier until it has finished with the expected number of alerts
+func runUntil(t *testing.T, notifier *WebhookNotifier, done func(Stats) bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.Run(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for !done(notifier.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out, stats %+v", notifier.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testAlert(rule string) models.Alert {
+	return models.Alert{Rule: rule, Source: "test", State: models.Firing, Time: time.Now(), Summary: "test alert"}
+}
+
+func TestDeliveryWithRetries(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	notifier := NewWebhookNotifier([]string{server.URL}, "secret").WithRetries(3, time.Millisecond)
+	notifier.Notify(testAlert("anomaly/enwiki"))
+	runUntil(t, notifier, func(stats Stats) bool { return stats.Delivered == 1 })
+
+	requests, alerts, signed := r.snapshot()
+	if requests != 3 {
+		t.Errorf("requests: got %d, want 3", requests)
+	}
+	if stats := notifier.Stats(); stats.Retried != 2 {
+		t.Errorf("retried: got %d, want 2", stats.Retried)
+	}
+	if len(alerts) != 1 || alerts[0].Rule != "anomaly/enwiki" || alerts[0].State != models.Firing {
+		t.Errorf("alerts: got %+v", alerts)
+	}
+	if !signed {
+		t.Error("Signature header missing or wrong")
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+	}{
+		{name: "Retries exhausted", statuses: []int{500, 502, 503, 504}, wantRequests: 3},
+		{name: "Client error not retried", statuses: []int{http.StatusBadRequest}, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &receiver{statuses: tt.statuses}
+			server := httptest.NewServer(r)
+			defer server.Close()
+
+			filename := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+			notifier := NewWebhookNotifier([]string{server.URL}, "").
+				WithRetries(3, time.Millisecond).
+				WithDeadLetter(filename)
+			notifier.Notify(testAlert("edit-war/enwiki"))
+			runUntil(t, notifier, func(stats Stats) bool { return stats.DeadLettered == 1 })
+
+			if requests, _, _ := r.snapshot(); requests != tt.wantRequests {
+				t.Errorf("requests: got %d, want %d", requests, tt.wantRequests)
+			}
+			file, err := os.Open(filename)
+			if err != nil {
+				t.Fatalf("Error opening dead letter file: %v", err)
+			}
+			defer file.Close()
+			scanner := bufio.NewScanner(file)
+			if !scanner.Scan() {
+				t.Fatal("Dead letter file empty")
+			}
+			var letter deadLetter
+			if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+				t.Fatalf("Error parsing dead letter: %v", err)
+			}
+			if letter.URL != server.URL || letter.Alert.Rule != "edit-war/enwiki" || letter.Error == "" {
+				t.Errorf("dead letter: got %+v", letter)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	notifier := NewWebhookNotifier([]string{server.URL}, "").WithRateLimit(time.Hour, 2)
+	for range 5 {
+		notifier.Notify(testAlert("anomaly/enwiki"))
+	}
+	notifier.Notify(testAlert("anomaly/dewiki"))
+	runUntil(t, notifier, func(stats Stats) bool { return stats.Delivered == 3 })
+
+	if stats := notifier.Stats(); stats.RateLimited != 3 {
+		t.Errorf("rate limited: got %d, want 3", stats.RateLimited)
+	}
+}
+
+func TestRateLimitResolutions(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	notifier := NewWebhookNotifier([]string{server.URL}, "").WithRateLimit(time.Hour, 1)
+	resolved := func(rule string) models.Alert {
+		alert := testAlert(rule)
+		alert.State = models.Resolved
+		return alert
+	}
+	// The bucket is empty after the first alert, but its resolution still goes out. The second firing
+	// alert is limited, so its resolution is too
+	notifier.Notify(testAlert("anomaly/enwiki"))
+	notifier.Notify(resolved("anomaly/enwiki"))
+	notifier.Notify(testAlert("anomaly/enwiki"))
+	notifier.Notify(resolved("anomaly/enwiki"))
+	runUntil(t, notifier, func(stats Stats) bool { return stats.Delivered == 2 })
+
+	if stats := notifier.Stats(); stats.RateLimited != 2 {
+		t.Errorf("rate limited: got %d, want 2", stats.RateLimited)
+	}
+	if _, alerts, _ := r.snapshot(); len(alerts) != 2 || alerts[0].State != models.Firing || alerts[1].State != models.Resolved {
+		t.Errorf("alerts: got %+v, want firing then resolved", alerts)
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	limiter := newRateLimiter(time.Minute, 1)
+	now := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+	if !limiter.allow("rule", now) {
+		t.Fatal("First alert limited")
+	}
+	if limiter.allow("rule", now.Add(30*time.Second)) {
+		t.Error("Alert allowed before refill")
+	}
+	if !limiter.allow("rule", now.Add(time.Minute)) {
+		t.Error("Alert limited after refill")
+	}
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"bufio"
+	"log"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -34,3 +36,17 @@ func LoadEnv(filename string) error {
 
 	return scanner.Err()
 }
+
+// Read an integer from the environment, using the fallback if it is unset or invalid
+func GetEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Error converting %s=%s to int, defaulting to %d", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}